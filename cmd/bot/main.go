@@ -1,20 +1,115 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/joho/godotenv"
 	"github.com/shabbirtoha/telegram-mail-bot/internal/bot"
 )
 
+// defaultDrainTimeout is how long shutdown waits for running work
+const defaultDrainTimeout = 30 * time.Second
+
 func main() {
+	if len(os.Args) > 1 {
+		runSubcommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	b, err := bot.NewBotFromEnv()
 	if err != nil {
 		log.Fatalf("failed to initialize bot: %v", err)
 	}
 
-	// start scheduler worker (reads scheduled emails from DB and sends them)
-	go b.StartScheduledWorker()
+	drainTimeout := defaultDrainTimeout
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if drainTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("SHUTDOWN_TIMEOUT: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// scheduler worker (reads scheduled emails from DB and sends them), inbox,
+	// janitor, HTTP server and the update loop all stop when ctx is cancelled
+	var wg sync.WaitGroup
+	for _, run := range []func(context.Context){
+		b.StartScheduledWorker,
+		b.StartInboxWatcher,
+		b.StartAttachmentJanitor,
+		b.StartHTTPServer,
+		b.Start,
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx)
+		}()
+	}
 
 	log.Println("🤖 Bot is running...")
-	b.Start()
+	<-ctx.Done()
+	stop() // a second signal kills the process right away
+
+	log.Printf("shutting down, waiting up to %s for in-flight deliveries", drainTimeout)
+	if err := b.Drain(wg.Wait, drainTimeout); err != nil {
+		log.Println(err)
+	}
+	if err := b.Close(); err != nil {
+		log.Println("close:", err)
+	}
+	log.Println("bye")
+}
+
+// runSubcommand handles maintenance commands that run without Telegram
+func runSubcommand(name string, args []string) {
+	_ = godotenv.Load()
+
+	switch name {
+	case "gen-key":
+		key, err := bot.GenerateKey()
+		if err != nil {
+			log.Fatalf("generate key: %v", err)
+		}
+		fmt.Println(key)
+	case "rotate-key":
+		fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+		dbPath := fs.String("db", "botdata.db", "path to the sqlite database")
+		newKeyFile := fs.String("new-key-file", "", "file holding the new master key (default: NEW_MASTER_KEY env)")
+		_ = fs.Parse(args)
+
+		oldKey, err := bot.LoadMasterKey()
+		if err != nil || oldKey == nil {
+			log.Fatalf("current key: set MASTER_KEY or MASTER_KEY_FILE (%v)", err)
+		}
+		var newKey []byte
+		if *newKeyFile != "" {
+			newKey, err = bot.ReadKeyFile(*newKeyFile)
+		} else if v := os.Getenv("NEW_MASTER_KEY"); v != "" {
+			newKey, err = bot.ParseKey(v)
+		} else {
+			log.Fatal("new key: pass -new-key-file or set NEW_MASTER_KEY")
+		}
+		if err != nil {
+			log.Fatalf("new key: %v", err)
+		}
+
+		n, err := bot.RotateMasterKey(*dbPath, oldKey, newKey)
+		if err != nil {
+			log.Fatalf("rotate key: %v", err)
+		}
+		fmt.Printf("re-encrypted %d account(s); update MASTER_KEY to the new key before restarting the bot\n", n)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\nusage: bot [gen-key | rotate-key [-db path] [-new-key-file path]]\n", name)
+		os.Exit(2)
+	}
 }
@@ -3,8 +3,28 @@ module github.com/shabbirtoha/telegram-mail-bot
 go 1.25.3
 
 require (
-	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.2-0.20221020003552-4126fa611266
 	github.com/joho/godotenv v1.5.1
 )
 
-require github.com/mattn/go-sqlite3 v1.14.32
+require (
+	github.com/emersion/go-imap v1.2.1
+	github.com/emersion/go-message v0.17.0
+	github.com/emersion/go-msgauth v0.6.8
+	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21
+	github.com/mattn/go-sqlite3 v1.14.32
+	go.mozilla.org/pkcs7 v0.9.0
+	software.sslmate.com/src/go-pkcs12 v0.5.0
+)
+
+require (
+	github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
+
+require (
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/cloudflare/circl v1.6.0 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+// Chat accounts never point at files on the server; their signing keys and
+// CA bundles are uploaded as documents and stored with the account.
+
+// maxKeyUploadSize bounds uploaded keys, certificate bundles and CA bundles
+const maxKeyUploadSize = 1 << 20
+
+const keyUploadUsage = "Send the file with a caption naming the account and what it is for:\n" +
+	"account=<name> password=<bundle password> for an S/MIME .p12/.pfx\n" +
+	"account=<name> use=dkim for a DKIM private key (.pem)\n" +
+	"account=<name> use=pgp passphrase=<passphrase> for a PGP signing key (.asc)\n" +
+	"account=<name> use=ca for a CA bundle (.pem)"
+
+// isPKCS12File reports whether an uploaded document looks like a PKCS#12 bundle
+func isPKCS12File(doc *tgbotapi.Document) bool {
+	ext := strings.ToLower(filepath.Ext(doc.FileName))
+	return ext == ".p12" || ext == ".pfx" || doc.MimeType == "application/x-pkcs12"
+}
+
+// isAccountKeyUpload reports whether a document is key material for one of
+// the chat's accounts: a PKCS#12 bundle, or any file captioned account= use=
+func isAccountKeyUpload(msg *tgbotapi.Message) bool {
+	if msg.Document == nil {
+		return false
+	}
+	if isPKCS12File(msg.Document) {
+		return true
+	}
+	kv, err := parseKeyValues(msg.Caption)
+	return err == nil && kv["account"] != "" && kv["use"] != ""
+}
+
+// handleAccountKeyUpload attaches an uploaded key, certificate bundle or CA
+// bundle to one of the chat's accounts, e.g. with the caption
+// account=work password=secret for a .p12
+func (b *Bot) handleAccountKeyUpload(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	// the upload may hold a private key and the caption its password
+	if _, err := b.API.Request(tgbotapi.NewDeleteMessage(chatID, msg.MessageID)); err != nil {
+		log.Printf("account key: could not delete upload message in chat %d: %v", chatID, err)
+	}
+
+	kv, err := parseKeyValues(msg.Caption)
+	if err != nil || kv["account"] == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, keyUploadUsage))
+		return
+	}
+	use := strings.ToLower(kv["use"])
+	if use == "" && isPKCS12File(msg.Document) {
+		use = "smime"
+	}
+	if msg.Document.FileSize > maxKeyUploadSize {
+		b.API.Send(tgbotapi.NewMessage(chatID, "The file is too large for a key or certificate."))
+		return
+	}
+	acct, err := b.accountByName(chatID, kv["account"])
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No account named "+kv["account"]+". Keys for the default account are set by the operator in the environment."))
+		return
+	}
+	data, err := b.downloadFile(msg.Document.FileID)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to download the file: "+err.Error()))
+		return
+	}
+
+	var done string
+	switch use {
+	case "smime":
+		signer, err := mail.ParsePKCS12(data, kv["password"])
+		if err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Invalid certificate: "+err.Error()))
+			return
+		}
+		acct.SMIMEData = base64.StdEncoding.EncodeToString(data)
+		acct.SMIMEPassword = kv["password"]
+		done = "🔏 Mail from " + acct.Name + " will be S/MIME signed as " + signer.Cert.Subject.CommonName +
+			" (valid until " + signer.Cert.NotAfter.Format("2006-01-02") + ")."
+	case "dkim":
+		if _, err := mail.ParseDKIMKey(data); err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Invalid DKIM key: "+err.Error()))
+			return
+		}
+		acct.DKIMKeyData = string(data)
+		done = "✍️ DKIM key stored for " + acct.Name + "."
+		if acct.DKIMDomain == "" || acct.DKIMSelector == "" {
+			done += " Signing starts once the account also has dkim_domain= and dkim_selector=; /addaccount replaces the account, so upload the key again after it."
+		}
+	case "pgp":
+		signer, err := mail.ParsePGPSigner(data, kv["passphrase"])
+		if err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Invalid PGP signing key: "+err.Error()))
+			return
+		}
+		acct.PGPKeyData = string(data)
+		acct.PGPPassphrase = kv["passphrase"]
+		done = fmt.Sprintf("🔏 Mail from %s will be PGP signed with key %s.", acct.Name, mail.PGPFingerprint(signer))
+	case "ca":
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Invalid CA bundle: no PEM certificates found."))
+			return
+		}
+		acct.CAPEM = string(data)
+		done = "🔒 " + acct.Name + " now also trusts the uploaded CA bundle."
+	default:
+		b.API.Send(tgbotapi.NewMessage(chatID, keyUploadUsage))
+		return
+	}
+	if err := b.saveAccount(acct); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to save the account: "+err.Error()))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, done))
+}
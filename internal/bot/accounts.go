@@ -0,0 +1,700 @@
+package bot
+
+import (
+	"crypto"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+// Account is an SMTP account mail is sent from. ID 0 is the account
+// configured through the environment.
+type Account struct {
+	ID       int64
+	ChatID   int64
+	Name     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+
+	// Auth is "plain" (default), "xoauth2" or "none"
+	Auth         string
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	Scope        string
+
+	// Key and CA material comes either from a file path, which only the
+	// operator can set through the environment, or from an upload in chat
+	// (the *Data and CAPEM fields), which is stored with the account.
+
+	TLSMode    mail.TLSMode
+	CAFile     string
+	CAPEM      string
+	ServerName string
+
+	// DKIM signing is enabled when domain, selector and a key are all set
+	DKIMDomain   string
+	DKIMSelector string
+	DKIMKeyFile  string
+	DKIMKeyData  string
+
+	// PGP signing key: an armored private key used to sign outgoing mail
+	PGPKeyFile    string
+	PGPKeyData    string
+	PGPPassphrase string
+
+	// S/MIME signing certificate: a PKCS#12 bundle, base64 in SMIMEData
+	SMIMEFile     string
+	SMIMEData     string
+	SMIMEPassword string
+
+	// IMAPHost enables forwarding new mail from IMAPFolder (INBOX by default)
+	// to the account's chat; the SMTP credentials are used to log in
+	IMAPHost   string
+	IMAPPort   int
+	IMAPFolder string
+	IMAPTLS    mail.TLSMode
+
+	// MaxSizeMB is the largest message the provider accepts (25 when unset)
+	MaxSizeMB int
+}
+
+// secretFields are account fields that are only ever stored encrypted
+var secretFields = map[string]bool{
+	"password":       true,
+	"client_secret":  true,
+	"refresh_token":  true,
+	"dkim_key_data":  true,
+	"pgp_key_data":   true,
+	"pgp_passphrase": true,
+	"smime_data":     true,
+	"smime_password": true,
+}
+
+// operatorOnlyFields name files on the server. Only the environment account
+// may use them; a chat uploads the key or CA bundle instead.
+var operatorOnlyFields = map[string]string{
+	"ca_file":   "send the CA bundle (.pem) with the caption account=<name> use=ca",
+	"dkim_key":  "send the DKIM private key (.pem) with the caption account=<name> use=dkim",
+	"pgp_key":   "send the PGP private key (.asc) with the caption account=<name> use=pgp passphrase=<passphrase>",
+	"smime_p12": "send the .p12 file with the caption account=<name> password=<bundle password>",
+}
+
+// String describes the account without any credentials so it is safe to log or show in chat
+func (a *Account) String() string {
+	return fmt.Sprintf("%s (%s via %s:%d)", a.Name, a.Username, a.Host, a.Port)
+}
+
+// GoString keeps %#v from printing credentials
+func (a *Account) GoString() string {
+	return "Account{" + a.String() + "}"
+}
+
+// set assigns a single field from a `key=value` pair
+func (a *Account) set(key, value string) error {
+	switch key {
+	case "name":
+		a.Name = value
+	case "host":
+		a.Host = value
+	case "port":
+		p, err := strconv.Atoi(value)
+		if err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		a.Port = p
+	case "user", "username":
+		a.Username = value
+	case "password", "pass":
+		a.Password = value
+	case "auth":
+		value = strings.ToLower(value)
+		if value != "plain" && value != "xoauth2" && value != "none" {
+			return fmt.Errorf("auth must be plain, xoauth2 or none")
+		}
+		a.Auth = value
+	case "token_url":
+		a.TokenURL = value
+	case "client_id":
+		a.ClientID = value
+	case "client_secret":
+		a.ClientSecret = value
+	case "refresh_token":
+		a.RefreshToken = value
+	case "scope":
+		a.Scope = value
+	case "tls":
+		mode, err := mail.ParseTLSMode(value)
+		if err != nil {
+			return err
+		}
+		a.TLSMode = mode
+	case "ca_pem":
+		a.CAPEM = value
+	case "server_name":
+		a.ServerName = value
+	case "dkim_domain":
+		a.DKIMDomain = value
+	case "dkim_selector":
+		a.DKIMSelector = value
+	case "dkim_key_data":
+		a.DKIMKeyData = value
+	case "pgp_key_data":
+		a.PGPKeyData = value
+	case "pgp_passphrase":
+		a.PGPPassphrase = value
+	case "smime_data":
+		a.SMIMEData = value
+	case "smime_password":
+		a.SMIMEPassword = value
+	case "max_size_mb":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid max_size_mb %q", value)
+		}
+		a.MaxSizeMB = n
+	case "imap_host":
+		a.IMAPHost = value
+	case "imap_port":
+		p, err := strconv.Atoi(value)
+		if err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid imap_port %q", value)
+		}
+		a.IMAPPort = p
+	case "imap_folder":
+		a.IMAPFolder = value
+	case "imap_tls":
+		mode, err := mail.ParseTLSMode(value)
+		if err != nil {
+			return err
+		}
+		a.IMAPTLS = mode
+	default:
+		if hint, ok := operatorOnlyFields[key]; ok {
+			return fmt.Errorf("%s takes a file on the server, which only the operator can set; %s", key, hint)
+		}
+		return fmt.Errorf("unknown account field %q", key)
+	}
+	return nil
+}
+
+// settings returns the account fields that are not stored in their own columns
+func (a *Account) settings() map[string]string {
+	return map[string]string{
+		"password":       a.Password,
+		"auth":           a.Auth,
+		"token_url":      a.TokenURL,
+		"client_id":      a.ClientID,
+		"client_secret":  a.ClientSecret,
+		"refresh_token":  a.RefreshToken,
+		"scope":          a.Scope,
+		"tls":            string(a.TLSMode),
+		"ca_pem":         a.CAPEM,
+		"server_name":    a.ServerName,
+		"dkim_domain":    a.DKIMDomain,
+		"dkim_selector":  a.DKIMSelector,
+		"dkim_key_data":  a.DKIMKeyData,
+		"pgp_key_data":   a.PGPKeyData,
+		"pgp_passphrase": a.PGPPassphrase,
+		"smime_data":     a.SMIMEData,
+		"smime_password": a.SMIMEPassword,
+		"imap_host":      a.IMAPHost,
+		"imap_port":      intString(a.IMAPPort),
+		"imap_folder":    a.IMAPFolder,
+		"imap_tls":       string(a.IMAPTLS),
+		"max_size_mb":    intString(a.MaxSizeMB),
+	}
+}
+
+// intString formats an optional number, empty when unset
+func intString(p int) string {
+	if p == 0 {
+		return ""
+	}
+	return strconv.Itoa(p)
+}
+
+// fields splits the non-empty settings into plain options and secrets for storage
+func (a *Account) fields() (options, secrets map[string]string) {
+	options = map[string]string{}
+	secrets = map[string]string{}
+	for k, v := range a.settings() {
+		if v == "" {
+			continue
+		}
+		if secretFields[k] {
+			secrets[k] = v
+		} else {
+			options[k] = v
+		}
+	}
+	return options, secrets
+}
+
+// validate checks that the account has everything needed to send mail
+func (a *Account) validate() error {
+	if a.Name == "" || a.Host == "" || a.Port == 0 || a.Username == "" {
+		return errors.New("name, host, port and user are required")
+	}
+	if _, err := a.dkimSigner(); err != nil {
+		return err
+	}
+	if _, err := a.pgpSigner(); err != nil {
+		return err
+	}
+	if _, err := a.smimeSigner(); err != nil {
+		return err
+	}
+	switch a.Auth {
+	case "none":
+		return nil
+	case "xoauth2":
+		if a.ClientID == "" || a.RefreshToken == "" {
+			return errors.New("client_id and refresh_token are required for xoauth2")
+		}
+		return nil
+	}
+	if a.Password == "" {
+		return errors.New("password is required")
+	}
+	return nil
+}
+
+// ---------- Authentication ----------
+
+// dialConfig describes how to connect to the account's SMTP server
+func (a *Account) dialConfig() mail.DialConfig {
+	return mail.DialConfig{
+		Host:       a.Host,
+		Port:       a.Port,
+		Mode:       a.TLSMode,
+		ServerName: a.ServerName,
+		CAFile:     a.CAFile,
+		CAPEM:      []byte(a.CAPEM),
+	}
+}
+
+// dkimSigner returns the account's DKIM signer, nil when signing is not configured
+func (a *Account) dkimSigner() (*mail.DKIMSigner, error) {
+	hasKey := a.DKIMKeyFile != "" || a.DKIMKeyData != ""
+	if a.DKIMDomain == "" && a.DKIMSelector == "" && !hasKey {
+		return nil, nil
+	}
+	if a.DKIMDomain == "" || a.DKIMSelector == "" || !hasKey {
+		return nil, errors.New("DKIM needs a domain, selector and key")
+	}
+	var key crypto.Signer
+	var err error
+	if a.DKIMKeyData != "" {
+		key, err = mail.ParseDKIMKey([]byte(a.DKIMKeyData))
+	} else {
+		key, err = mail.LoadDKIMKey(a.DKIMKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mail.DKIMSigner{Domain: a.DKIMDomain, Selector: a.DKIMSelector, Key: key}, nil
+}
+
+// pgpSigner returns the account's unlocked PGP signing key, nil when none is configured
+func (a *Account) pgpSigner() (*openpgp.Entity, error) {
+	switch {
+	case a.PGPKeyData != "":
+		return mail.ParsePGPSigner([]byte(a.PGPKeyData), a.PGPPassphrase)
+	case a.PGPKeyFile != "":
+		return mail.LoadPGPSigner(a.PGPKeyFile, a.PGPPassphrase)
+	}
+	return nil, nil
+}
+
+// smimeSigner returns the account's S/MIME signer, nil when none is configured
+func (a *Account) smimeSigner() (*mail.SMIMESigner, error) {
+	switch {
+	case a.SMIMEData != "":
+		data, err := base64.StdEncoding.DecodeString(a.SMIMEData)
+		if err != nil {
+			return nil, fmt.Errorf("stored S/MIME certificate: %w", err)
+		}
+		return mail.ParsePKCS12(data, a.SMIMEPassword)
+	case a.SMIMEFile != "":
+		return mail.LoadPKCS12(a.SMIMEFile, a.SMIMEPassword)
+	}
+	return nil, nil
+}
+
+// poolKey identifies the account's pooled SMTP connection; it changes when the
+// connection settings do so an edited account never reuses a stale session
+func (a *Account) poolKey() string {
+	return fmt.Sprintf("%d|%s|%s:%d|%s|%s", a.ID, a.Username, a.Host, a.Port, a.TLSMode, a.Auth)
+}
+
+// smtpAuth returns the smtp.Auth for the account, nil when it sends unauthenticated
+func (b *Bot) smtpAuth(acct *Account) smtp.Auth {
+	switch acct.Auth {
+	case "none":
+		return nil
+	case "xoauth2":
+		return mail.XOAuth2Auth(acct.Username, acct.Host, b.tokenSource(acct))
+	default:
+		return smtp.PlainAuth("", acct.Username, acct.Password, acct.Host)
+	}
+}
+
+// tokenSource returns the account's XOAUTH2 token source. Sources are kept per
+// account so access tokens are reused until shortly before expiry.
+func (b *Bot) tokenSource(acct *Account) *mail.TokenSource {
+	b.tokensMu.Lock()
+	defer b.tokensMu.Unlock()
+	ts, ok := b.tokens[acct.ID]
+	if !ok || ts.ClientID != acct.ClientID || ts.ClientSecret != acct.ClientSecret || ts.TokenURL != oauthTokenURL(acct) {
+		ts = &mail.TokenSource{
+			TokenURL:     oauthTokenURL(acct),
+			ClientID:     acct.ClientID,
+			ClientSecret: acct.ClientSecret,
+			RefreshToken: acct.RefreshToken,
+			Scope:        oauthScope(acct),
+		}
+		ts.OnRefreshTokenChange = b.refreshTokenRotated(*acct)
+		b.tokens[acct.ID] = ts
+	}
+	return ts
+}
+
+// refreshTokenRotated persists a refresh token the provider replaced
+func (b *Bot) refreshTokenRotated(acct Account) func(string) {
+	return func(token string) {
+		if acct.ID == 0 {
+			log.Printf("account %s: OAuth refresh token was rotated, update OAUTH_REFRESH_TOKEN before the next restart", acct.Name)
+			return
+		}
+		acct.RefreshToken = token
+		if err := b.saveAccount(&acct); err != nil {
+			log.Printf("account %s: failed to store rotated refresh token: %v", acct.Name, err)
+		}
+	}
+}
+
+// oauthTokenURL picks the token endpoint, guessing the provider from the SMTP host when unset
+func oauthTokenURL(acct *Account) string {
+	if acct.TokenURL != "" {
+		return acct.TokenURL
+	}
+	if isMicrosoftHost(acct.Host) {
+		return mail.MicrosoftTokenURL
+	}
+	return mail.GoogleTokenURL
+}
+
+func oauthScope(acct *Account) string {
+	if acct.Scope != "" || !isMicrosoftHost(acct.Host) {
+		return acct.Scope
+	}
+	return "https://outlook.office.com/SMTP.Send offline_access"
+}
+
+func isMicrosoftHost(host string) bool {
+	host = strings.ToLower(host)
+	return strings.HasSuffix(host, "office365.com") || strings.HasSuffix(host, "outlook.com")
+}
+
+// ---------- Storage ----------
+
+// saveAccount inserts or replaces the chat's account with the same name
+func (b *Bot) saveAccount(a *Account) error {
+	if b.secrets == nil {
+		return errors.New("account storage is disabled: set MASTER_KEY or MASTER_KEY_FILE")
+	}
+	options, secrets := a.fields()
+	optJSON, _ := json.Marshal(options)
+	secJSON, _ := json.Marshal(secrets)
+	sealed, err := b.secrets.Seal(secJSON)
+	if err != nil {
+		return fmt.Errorf("encrypt credentials: %w", err)
+	}
+
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	_, err = b.db.Exec(`INSERT INTO smtp_accounts
+	(chat_id, name, host, port, username, options_json, secrets_enc, active, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?)
+	ON CONFLICT(chat_id, name) DO UPDATE SET
+		host = excluded.host, port = excluded.port, username = excluded.username,
+		options_json = excluded.options_json, secrets_enc = excluded.secrets_enc`,
+		a.ChatID, a.Name, a.Host, a.Port, a.Username, string(optJSON), sealed, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	// /addaccount replaces an account by name, so look its id up; the next
+	// send builds a token source from the saved credentials
+	var id int64
+	if err := b.db.QueryRow("SELECT id FROM smtp_accounts WHERE chat_id = ? AND name = ?", a.ChatID, a.Name).Scan(&id); err == nil {
+		b.tokensMu.Lock()
+		delete(b.tokens, id)
+		b.tokensMu.Unlock()
+	}
+	return nil
+}
+
+const accountColumns = "id, chat_id, name, host, port, username, options_json, secrets_enc"
+
+// scanAccount reads an account row and decrypts its secrets
+func (b *Bot) scanAccount(row interface{ Scan(...any) error }) (*Account, error) {
+	var a Account
+	var optJSON, sealed string
+	if err := row.Scan(&a.ID, &a.ChatID, &a.Name, &a.Host, &a.Port, &a.Username, &optJSON, &sealed); err != nil {
+		return nil, err
+	}
+	if b.secrets == nil {
+		return nil, errors.New("account storage is disabled: set MASTER_KEY or MASTER_KEY_FILE")
+	}
+	plain, err := b.secrets.Open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %w", a.Name, err)
+	}
+	var options, secrets map[string]string
+	_ = json.Unmarshal([]byte(optJSON), &options)
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("account %s: %w", a.Name, err)
+	}
+	for k, v := range options {
+		if _, ok := operatorOnlyFields[k]; ok {
+			// stored before chats were limited to uploads
+			log.Printf("account %s: ignoring %s=%s, a chat account cannot use files on the server", a.Name, k, v)
+			continue
+		}
+		if err := a.set(k, v); err != nil {
+			return nil, fmt.Errorf("account %s: %w", a.Name, err)
+		}
+	}
+	for k, v := range secrets {
+		if err := a.set(k, v); err != nil {
+			return nil, fmt.Errorf("account %s: %w", a.Name, err)
+		}
+	}
+	return &a, nil
+}
+
+// accountByName loads one of the chat's accounts
+func (b *Bot) accountByName(chatID int64, name string) (*Account, error) {
+	row := b.db.QueryRow("SELECT "+accountColumns+" FROM smtp_accounts WHERE chat_id = ? AND name = ?", chatID, name)
+	return b.scanAccount(row)
+}
+
+// accountByID resolves an account id, where 0 is the environment account
+func (b *Bot) accountByID(id int64) (*Account, error) {
+	if id == 0 {
+		return b.defaultAccount, nil
+	}
+	row := b.db.QueryRow("SELECT "+accountColumns+" FROM smtp_accounts WHERE id = ?", id)
+	a, err := b.scanAccount(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("account %d no longer exists", id)
+	}
+	return a, err
+}
+
+// activeAccountID returns the account the chat currently sends from, 0 for the default
+func (b *Bot) activeAccountID(chatID int64) int64 {
+	var id int64
+	err := b.db.QueryRow("SELECT id FROM smtp_accounts WHERE chat_id = ? AND active = 1", chatID).Scan(&id)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// RotateMasterKey re-encrypts every stored credential from oldKey to newKey
+// in a single transaction and returns the number of accounts updated.
+func RotateMasterKey(dbPath string, oldKey, newKey []byte) (int, error) {
+	oldBox, err := NewSecretBox(oldKey)
+	if err != nil {
+		return 0, fmt.Errorf("old key: %w", err)
+	}
+	newBox, err := NewSecretBox(newKey)
+	if err != nil {
+		return 0, fmt.Errorf("new key: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("open sqlite: %w", err)
+	}
+	defer db.Close()
+	if err := initDB(db); err != nil {
+		return 0, fmt.Errorf("init db: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, secrets_enc FROM smtp_accounts")
+	if err != nil {
+		return 0, err
+	}
+	resealed := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var sealed string
+		if err := rows.Scan(&id, &sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain, err := oldBox.Open(sealed)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("account %d: %w", id, err)
+		}
+		if resealed[id], err = newBox.Seal(plain); err != nil {
+			rows.Close()
+			return 0, err
+		}
+	}
+	rows.Close()
+
+	for id, sealed := range resealed {
+		if _, err := tx.Exec("UPDATE smtp_accounts SET secrets_enc = ? WHERE id = ?", sealed, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(resealed), tx.Commit()
+}
+
+// ---------- Commands ----------
+
+// cmdAddAccount stores an SMTP account: /addaccount name=work host=smtp.example.com port=587 user=me@example.com password=secret
+func (b *Bot) cmdAddAccount(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	// the command text carries the password, never leave it in the chat history
+	if _, err := b.API.Request(tgbotapi.NewDeleteMessage(chatID, msg.MessageID)); err != nil {
+		log.Printf("addaccount: could not delete credential message in chat %d: %v", chatID, err)
+	}
+
+	kv, err := parseKeyValues(msg.CommandArguments())
+	if err != nil || len(kv) == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /addaccount name=<name> host=<smtp host> port=<port> user=<username> password=<password>\n"+
+			"For OAuth use auth=xoauth2 client_id=<id> client_secret=<secret> refresh_token=<token> [token_url=<url>] [scope=<scope>] instead of password.\n"+
+			"Add imap_host=<imap host> [imap_port=993] [imap_folder=INBOX] to get new mail forwarded here."))
+		return
+	}
+	a := &Account{ChatID: chatID}
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := a.set(k, kv[k]); err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Invalid account: "+err.Error()))
+			return
+		}
+	}
+	if err := a.validate(); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Invalid account: "+err.Error()))
+		return
+	}
+	if strings.EqualFold(a.Name, "default") {
+		b.API.Send(tgbotapi.NewMessage(chatID, "The name `default` is reserved for the built-in account."))
+		return
+	}
+	if err := b.saveAccount(a); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to save account: "+err.Error()))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🔐 Account "+a.String()+" saved, credentials are stored encrypted. Use /useaccount "+a.Name+" to send from it."))
+}
+
+func (b *Bot) cmdListAccounts(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	rows, err := b.db.Query("SELECT name, host, port, username, active FROM smtp_accounts WHERE chat_id = ? ORDER BY name", chatID)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to query accounts: "+err.Error()))
+		return
+	}
+	defer rows.Close()
+
+	anyActive := false
+	var lines []string
+	for rows.Next() {
+		var a Account
+		var active bool
+		_ = rows.Scan(&a.Name, &a.Host, &a.Port, &a.Username, &active)
+		mark := "  "
+		if active {
+			mark, anyActive = "✅", true
+		}
+		lines = append(lines, mark+" "+a.String())
+	}
+	def := "  "
+	if !anyActive {
+		def = "✅"
+	}
+	lines = append([]string{def + " default (" + b.defaultAccount.Username + ")"}, lines...)
+	b.API.Send(tgbotapi.NewMessage(chatID, "📮 Accounts:\n"+strings.Join(lines, "\n")))
+}
+
+func (b *Bot) cmdUseAccount(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	name := strings.TrimSpace(msg.CommandArguments())
+	if name == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /useaccount <name|default>"))
+		return
+	}
+
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	if strings.EqualFold(name, "default") {
+		_, _ = b.db.Exec("UPDATE smtp_accounts SET active = 0 WHERE chat_id = ?", chatID)
+		b.API.Send(tgbotapi.NewMessage(chatID, "✅ Sending from the default account."))
+		return
+	}
+	res, err := b.db.Exec("UPDATE smtp_accounts SET active = (name = ?) WHERE chat_id = ? AND EXISTS (SELECT 1 FROM smtp_accounts WHERE chat_id = ? AND name = ?)", name, chatID, chatID, name)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to switch account: "+err.Error()))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No account named "+name+". See /accounts."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "✅ Sending from account "+name+"."))
+}
+
+func (b *Bot) cmdDeleteAccount(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	name := strings.TrimSpace(msg.CommandArguments())
+	if name == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /delaccount <name>"))
+		return
+	}
+
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	res, err := b.db.Exec("DELETE FROM smtp_accounts WHERE chat_id = ? AND name = ?", chatID, name)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to delete account: "+err.Error()))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No account named "+name+"."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🗑 Account "+name+" deleted."))
+}
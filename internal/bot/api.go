@@ -0,0 +1,475 @@
+package bot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- REST API ----------
+
+// The API lets other services send and schedule mail through the same
+// scheduled_emails table and worker as the chat. Every request carries an API
+// key created with /newapikey; it acts as the chat that created it.
+
+const (
+	// apiRequestOverhead is allowed on top of the send limit for the other
+	// fields and the JSON or multipart framing
+	apiRequestOverhead = 1 << 20
+	// maxAPIField bounds a multipart form field other than the attachment
+	maxAPIField = 1 << 20
+)
+
+// apiMessageRequest is the body of POST /v1/messages. Multipart requests use
+// form fields of the same names plus an "attachment" file.
+type apiMessageRequest struct {
+	To      []string `json:"to"`
+	Cc      []string `json:"cc"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+	SendAt  string   `json:"send_at"`
+	Account string   `json:"account"`
+	Encrypt bool     `json:"encrypt"`
+	AsLink  bool     `json:"as_link"`
+
+	file *apiUpload
+}
+
+// apiUpload is the attachment of a multipart request
+type apiUpload struct {
+	Name, Type string
+	Data       []byte
+}
+
+// apiMessage is a scheduled email as the API reports it
+type apiMessage struct {
+	ID         int64    `json:"id"`
+	Status     string   `json:"status"`
+	To         []string `json:"to"`
+	Cc         []string `json:"cc,omitempty"`
+	Subject    string   `json:"subject"`
+	Attachment string   `json:"attachment,omitempty"`
+	SendAt     string   `json:"send_at"`
+	CreatedAt  string   `json:"created_at"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// apiError is an error reported to the client with its HTTP status
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string { return e.msg }
+
+func badRequest(format string, args ...any) error {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func unprocessable(format string, args ...any) error {
+	return &apiError{http.StatusUnprocessableEntity, fmt.Sprintf(format, args...)}
+}
+
+// registerAPI adds the /v1 endpoints to the HTTP server
+func (b *Bot) registerAPI() {
+	b.mux.HandleFunc("POST /v1/messages", b.apiAuth(b.apiCreateMessage))
+	b.mux.HandleFunc("GET /v1/messages/{id}", b.apiAuth(b.apiGetMessage))
+	b.mux.HandleFunc("DELETE /v1/messages/{id}", b.apiAuth(b.apiCancelMessage))
+	b.mux.HandleFunc("GET /v1/scheduled", b.apiAuth(b.apiListScheduled))
+}
+
+// apiAuth resolves the request's bearer API key to its chat before calling h
+func (b *Bot) apiAuth(h func(w http.ResponseWriter, r *http.Request, chatID int64)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, &apiError{http.StatusUnauthorized, "missing API key, send Authorization: Bearer <key>"})
+			return
+		}
+		chatID, ok := b.apiKeyChat(strings.TrimSpace(key))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, &apiError{http.StatusUnauthorized, "invalid API key"})
+			return
+		}
+		h(w, r, chatID)
+	}
+}
+
+// apiCreateMessage handles POST /v1/messages: the email is stored as a
+// scheduled email, due now when send_at is left out
+func (b *Bot) apiCreateMessage(w http.ResponseWriter, r *http.Request, chatID int64) {
+	limit := b.apiRequestLimit(chatID)
+	if r.ContentLength > limit {
+		writeAPIError(w, requestTooLarge(limit))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	req, err := parseAPIMessage(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	session, err := b.apiSession(chatID, req, time.Now())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if req.file != nil {
+		hash, err := b.acceptUpload(chatID, req.file.Name, req.file.Data)
+		if err != nil {
+			writeAPIError(w, unprocessable("%v", err))
+			return
+		}
+		session.FileHash, session.FileName, session.FileType = hash, req.file.Name, req.file.Type
+	}
+	if err := b.checkSessionSize(session); err != nil {
+		writeAPIError(w, &apiError{http.StatusRequestEntityTooLarge, err.Error()})
+		return
+	}
+
+	id, err := b.schedulePersist(session)
+	if err != nil {
+		log.Printf("api: store message for chat %d: %v", chatID, err)
+		writeAPIError(w, err)
+		return
+	}
+	if req.SendAt == "" {
+		b.wakeScheduler()
+	}
+	m, err := b.apiMessageByID(chatID, id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/messages/%d", id))
+	writeJSON(w, http.StatusAccepted, m)
+}
+
+// parseAPIMessage reads a JSON or multipart/form-data message request
+func parseAPIMessage(r *http.Request) (*apiMessageRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var req apiMessageRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return nil, requestBodyError(err)
+		}
+		return &req, nil
+	case "multipart/form-data":
+		return parseAPIForm(r)
+	}
+	return nil, &apiError{http.StatusUnsupportedMediaType, "send application/json or multipart/form-data"}
+}
+
+// parseAPIForm reads a multipart request part by part, so the attachment
+// goes straight into memory instead of through a temporary file
+func parseAPIForm(r *http.Request) (*apiMessageRequest, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, badRequest("invalid request body: %v", err)
+	}
+	req := &apiMessageRequest{}
+	values := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, requestBodyError(err)
+		}
+		name := part.FormName()
+		if part.FileName() == "" {
+			v, err := io.ReadAll(io.LimitReader(part, maxAPIField+1))
+			if err != nil {
+				return nil, requestBodyError(err)
+			}
+			if len(v) > maxAPIField {
+				return nil, badRequest("%s: longer than %d KB", name, maxAPIField>>10)
+			}
+			switch name {
+			case "to":
+				req.To = append(req.To, string(v))
+			case "cc":
+				req.Cc = append(req.Cc, string(v))
+			default:
+				if _, ok := values[name]; !ok {
+					values[name] = string(v)
+				}
+			}
+			continue
+		}
+		if name != "attachment" {
+			continue
+		}
+		if req.file != nil {
+			return nil, badRequest("only one attachment per email is supported")
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, requestBodyError(err)
+		}
+		fileName := filepath.Base(part.FileName())
+		ctype := part.Header.Get("Content-Type")
+		if ctype == "" || ctype == "application/octet-stream" {
+			ctype = orName(mime.TypeByExtension(filepath.Ext(fileName)), "application/octet-stream")
+		}
+		req.file = &apiUpload{Name: orName(fileName, "attachment"), Type: ctype, Data: data}
+	}
+
+	req.Subject, req.Body = values["subject"], values["body"]
+	req.SendAt, req.Account = values["send_at"], values["account"]
+	for name, flag := range map[string]*bool{"encrypt": &req.Encrypt, "as_link": &req.AsLink} {
+		if v := values[name]; v != "" {
+			if *flag, err = strconv.ParseBool(v); err != nil {
+				return nil, badRequest("%s: want true or false", name)
+			}
+		}
+	}
+	return req, nil
+}
+
+// apiRequestLimit bounds a POST /v1/messages body. No email can be larger than
+// the send limit of the chat's largest account, so neither can its request.
+func (b *Bot) apiRequestLimit(chatID int64) int64 {
+	limit := b.defaultAccount.sizeLimit()
+	rows, err := b.db.Query("SELECT "+accountColumns+" FROM smtp_accounts WHERE chat_id = ?", chatID)
+	if err != nil {
+		log.Printf("api: query accounts of chat %d: %v", chatID, err)
+		return limit + apiRequestOverhead
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if a, err := b.scanAccount(rows); err == nil {
+			limit = max(limit, a.sizeLimit())
+		}
+	}
+	return limit + apiRequestOverhead
+}
+
+// requestBodyError reports an unreadable body, or one over the request limit
+func requestBodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return requestTooLarge(tooLarge.Limit)
+	}
+	return badRequest("invalid request body: %v", err)
+}
+
+func requestTooLarge(limit int64) *apiError {
+	return &apiError{http.StatusRequestEntityTooLarge, fmt.Sprintf("request is over %d MB, more than any account of this chat can send", limit>>20)}
+}
+
+// apiSession validates a request and turns it into the session the
+// scheduled worker sends
+func (b *Bot) apiSession(chatID int64, req *apiMessageRequest, now time.Time) (*EmailSession, error) {
+	var to, cc []string
+	for _, v := range req.To {
+		to = append(to, splitRecipients(v)...)
+	}
+	for _, v := range req.Cc {
+		cc = append(cc, splitRecipients(v)...)
+	}
+	if len(to) == 0 {
+		return nil, badRequest("to: at least one recipient is required")
+	}
+	for _, addr := range append(to, cc...) {
+		if !strings.Contains(addr, "@") {
+			return nil, badRequest("%q is not an email address", addr)
+		}
+	}
+	if strings.TrimSpace(req.Subject) == "" || strings.TrimSpace(req.Body) == "" {
+		return nil, badRequest("subject and body are required")
+	}
+	sendAt, err := apiSendTime(req.SendAt, now)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &EmailSession{
+		To:        strings.Join(to, ", "),
+		Cc:        strings.Join(cc, ", "),
+		Subject:   req.Subject,
+		Body:      req.Body,
+		Schedule:  sendAt,
+		ChatID:    chatID,
+		AccountID: b.activeAccountID(chatID),
+		Encrypt:   req.Encrypt,
+		AsLink:    req.AsLink,
+		CreatedAt: now.UTC(),
+	}
+	switch req.Account {
+	case "":
+	case "default":
+		session.AccountID = 0
+	default:
+		acct, err := b.accountByName(chatID, req.Account)
+		if err != nil {
+			return nil, unprocessable("no account named %q, see /accounts", req.Account)
+		}
+		session.AccountID = acct.ID
+	}
+	if req.AsLink && !b.linksEnabled() {
+		return nil, unprocessable("as_link needs HTTP_ADDR and PUBLIC_URL")
+	}
+	if req.Encrypt {
+		if missing := b.missingPGPKeys(chatID, allRecipients(session)); len(missing) > 0 {
+			return nil, unprocessable("encrypt: no PGP key for %s", strings.Join(missing, ", "))
+		}
+	}
+	return session, nil
+}
+
+// apiSendTime validates send_at and returns it in UTC; an empty send_at means now
+func apiSendTime(sendAt string, now time.Time) (string, error) {
+	if sendAt == "" {
+		return now.UTC().Format(time.RFC3339), nil
+	}
+	t, err := time.Parse(time.RFC3339, sendAt)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02 15:04", sendAt); err != nil {
+			return "", badRequest("send_at: use RFC 3339 such as 2026-01-02T15:04:05Z, or YYYY-MM-DD HH:MM in UTC")
+		}
+	}
+	if !t.After(now) {
+		return "", badRequest("send_at %s has already passed; leave it out to send now", sendAt)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// apiGetMessage handles GET /v1/messages/{id}
+func (b *Bot) apiGetMessage(w http.ResponseWriter, r *http.Request, chatID int64) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, &apiError{http.StatusNotFound, "no such message"})
+		return
+	}
+	m, err := b.apiMessageByID(chatID, id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// apiCancelMessage handles DELETE /v1/messages/{id}; only emails still
+// pending can be cancelled
+func (b *Bot) apiCancelMessage(w http.ResponseWriter, r *http.Request, chatID int64) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, &apiError{http.StatusNotFound, "no such message"})
+		return
+	}
+	b.dbMu.Lock()
+	res, err := b.db.Exec("UPDATE scheduled_emails SET status = 'cancelled' WHERE id = ? AND chat_id = ? AND status = 'pending'", id, chatID)
+	b.dbMu.Unlock()
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	n, _ := res.RowsAffected()
+	if n == 1 {
+		b.releaseAttachments(scheduledOwner(id))
+	}
+	m, err := b.apiMessageByID(chatID, id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if n == 0 {
+		writeAPIError(w, &apiError{http.StatusConflict, "message is already " + m.Status})
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// apiListScheduled handles GET /v1/scheduled: the chat's pending emails, soonest first
+func (b *Bot) apiListScheduled(w http.ResponseWriter, r *http.Request, chatID int64) {
+	rows, err := b.db.Query("SELECT "+apiMessageColumns+" FROM scheduled_emails WHERE chat_id = ? AND status = 'pending'", chatID)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	messages := []*apiMessage{}
+	for rows.Next() {
+		m, err := scanAPIMessage(rows)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	// send_at is normalized to RFC 3339 UTC, so it sorts as text
+	slices.SortStableFunc(messages, func(x, y *apiMessage) int { return strings.Compare(x.SendAt, y.SendAt) })
+	writeJSON(w, http.StatusOK, map[string]any{"messages": messages})
+}
+
+const apiMessageColumns = "id, status, recipients, cc, subject, attachments_json, send_at, created_at, error"
+
+func (b *Bot) apiMessageByID(chatID, id int64) (*apiMessage, error) {
+	row := b.db.QueryRow("SELECT "+apiMessageColumns+" FROM scheduled_emails WHERE id = ? AND chat_id = ?", id, chatID)
+	m, err := scanAPIMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &apiError{http.StatusNotFound, "no such message"}
+	}
+	return m, err
+}
+
+func scanAPIMessage(row interface{ Scan(...any) error }) (*apiMessage, error) {
+	var m apiMessage
+	var to, cc, attachmentsJSON, sendAt string
+	if err := row.Scan(&m.ID, &m.Status, &to, &cc, &m.Subject, &attachmentsJSON, &sendAt, &m.CreatedAt, &m.Error); err != nil {
+		return nil, err
+	}
+	m.To, m.Cc = splitRecipients(to), splitRecipients(cc)
+	var attachments []map[string]string
+	_ = json.Unmarshal([]byte(attachmentsJSON), &attachments)
+	for _, a := range attachments {
+		if a["cid"] == "" {
+			m.Attachment = a["name"]
+		}
+	}
+	// emails scheduled from the chat store minutes in UTC
+	if t, err := time.Parse("2006-01-02 15:04", sendAt); err == nil {
+		sendAt = t.Format(time.RFC3339)
+	}
+	m.SendAt = sendAt
+	return &m, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError reports err as {"error": "..."}; errors without a status are
+// logged and hidden behind a 500
+func writeAPIError(w http.ResponseWriter, err error) {
+	var e *apiError
+	if !errors.As(err, &e) {
+		log.Println("api:", err)
+		e = &apiError{http.StatusInternalServerError, "internal error"}
+	}
+	writeJSON(w, e.status, map[string]string{"error": e.msg})
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPI serves the API of a test bot and returns a key for chat 5
+func newTestAPI(t *testing.T) (*Bot, *httptest.Server, string) {
+	t.Helper()
+	b, _ := newTestBot(t)
+	b.defaultAccount.MaxSizeMB = 1
+	b.registerAPI()
+	key, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.db.Exec("INSERT INTO api_keys (chat_id, name, key_hash, created_at) VALUES (5, 'test', ?, ?)",
+		apiKeyHash(key), time.Now().UTC().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(b.mux)
+	t.Cleanup(srv.Close)
+	return b, srv, key
+}
+
+// countingReader counts the bytes the server read from a request body
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func apiForm(t *testing.T, attachment []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range [][2]string{{"to", "a@example.com"}, {"to", "b@example.com"}, {"subject", "Report"}, {"body", "Attached."}} {
+		mw.WriteField(f[0], f[1])
+	}
+	fw, _ := mw.CreateFormFile("attachment", "report.txt")
+	fw.Write(attachment)
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestAPIRefusesOversizedRequestBeforeReading(t *testing.T) {
+	_, srv, key := newTestAPI(t)
+	body, ctype := apiForm(t, bytes.Repeat([]byte("x"), 3<<20))
+	size := body.Len()
+	cr := &countingReader{r: body}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/messages", cr)
+	req.ContentLength = int64(size)
+	req.Header.Set("Content-Type", ctype)
+	req.Header.Set("Authorization", "Bearer "+key)
+	// the server only asks for the body once the handler reads it
+	req.Header.Set("Expect", "100-continue")
+	client := srv.Client()
+	client.Transport.(*http.Transport).ExpectContinueTimeout = 5 * time.Second
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", resp.StatusCode)
+	}
+	if cr.n != 0 {
+		t.Errorf("%d of %d body bytes sent before the request was refused", cr.n, size)
+	}
+}
+
+func TestAPIStreamedRequestOverLimit(t *testing.T) {
+	_, srv, key := newTestAPI(t)
+	body, ctype := apiForm(t, bytes.Repeat([]byte("x"), 3<<20))
+	// no Content-Length: the limit is only found while reading
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/messages", io.MultiReader(body))
+	req.Header.Set("Content-Type", ctype)
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var e struct{ Error string }
+	json.NewDecoder(resp.Body).Decode(&e)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || !strings.Contains(e.Error, "2 MB") {
+		t.Fatalf("status %d %q, want 413 naming the 2 MB limit", resp.StatusCode, e.Error)
+	}
+}
+
+func TestAPIMultipartMessage(t *testing.T) {
+	b, srv, key := newTestAPI(t)
+	body, ctype := apiForm(t, []byte("numbers"))
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/messages", body)
+	req.Header.Set("Content-Type", ctype)
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status %d, want 202", resp.StatusCode)
+	}
+	var to, attachments string
+	if err := b.db.QueryRow("SELECT recipients, attachments_json FROM scheduled_emails WHERE chat_id = 5").Scan(&to, &attachments); err != nil {
+		t.Fatal(err)
+	}
+	if to != "a@example.com, b@example.com" || !strings.Contains(attachments, `"name":"report.txt"`) {
+		t.Errorf("stored recipients %q, attachments %s", to, attachments)
+	}
+}
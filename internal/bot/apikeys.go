@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ---------- API keys ----------
+
+// apiKeyPrefix marks the bot's API keys so they are easy to spot in configs and logs
+const apiKeyPrefix = "tmb_"
+
+// newAPIKey returns a random API key; only its hash is stored
+func newAPIKey() (string, error) {
+	var raw [32]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw[:]), nil
+}
+
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyChat returns the chat an API key was created in; requests made with
+// it send from that chat's accounts and see only its emails
+func (b *Bot) apiKeyChat(key string) (int64, bool) {
+	var chatID int64
+	err := b.db.QueryRow("SELECT chat_id FROM api_keys WHERE key_hash = ?", apiKeyHash(key)).Scan(&chatID)
+	return chatID, err == nil
+}
+
+// cmdNewAPIKey handles /newapikey <name>
+func (b *Bot) cmdNewAPIKey(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	name := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /newapikey <name>, e.g. /newapikey billing"))
+		return
+	}
+	key, err := newAPIKey()
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to create API key: "+err.Error()))
+		return
+	}
+	b.dbMu.Lock()
+	_, err = b.db.Exec("INSERT INTO api_keys (chat_id, name, key_hash, created_at) VALUES (?, ?, ?, ?)",
+		chatID, name, apiKeyHash(key), time.Now().UTC().Format(time.RFC3339))
+	b.dbMu.Unlock()
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			b.API.Send(tgbotapi.NewMessage(chatID, "An API key named "+name+" already exists. Delete it with /delapikey "+name+" first."))
+			return
+		}
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to save API key: "+err.Error()))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🔑 API key %s:\n\n%s\n\nIt is shown only once. Send it as the header Authorization: Bearer <key>. Emails sent with it use this chat's active account unless the request names another. Delete this message once you stored it.", name, key)))
+}
+
+func (b *Bot) cmdListAPIKeys(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	rows, err := b.db.Query("SELECT name, created_at FROM api_keys WHERE chat_id = ? ORDER BY name", chatID)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to query API keys: "+err.Error()))
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var name, created string
+		_ = rows.Scan(&name, &created)
+		lines = append(lines, fmt.Sprintf("%s — created %s", name, created))
+	}
+	if len(lines) == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No API keys yet. Create one with /newapikey <name>"))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🔑 API keys\n"+strings.Join(lines, "\n")))
+}
+
+func (b *Bot) cmdDeleteAPIKey(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	name := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	if name == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /delapikey <name>"))
+		return
+	}
+	b.dbMu.Lock()
+	res, err := b.db.Exec("DELETE FROM api_keys WHERE chat_id = ? AND name = ?", chatID, name)
+	b.dbMu.Unlock()
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to delete API key: "+err.Error()))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No API key named "+name+"."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🗑 API key "+name+" deleted; requests using it are refused from now on."))
+}
@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// splitArgs splits command arguments on whitespace, keeping double or
+// single quoted sections together. A backslash escapes the next character.
+func splitArgs(s string) ([]string, error) {
+	args, _, err := splitLeadingArgs(s, -1)
+	return args, err
+}
+
+// parseKeyValues parses `key=value` arguments into a map with lower-cased keys
+func parseKeyValues(s string) (map[string]string, error) {
+	args, err := splitArgs(s)
+	if err != nil {
+		return nil, err
+	}
+	kv := make(map[string]string, len(args))
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", a)
+		}
+		kv[strings.ToLower(k)] = v
+	}
+	return kv, nil
+}
+
+// argScanner reads arguments one at a time, so that commands can take the
+// rest of the line as free text. It is the tokenizer behind splitArgs.
+type argScanner struct {
+	s     string
+	pos   int
+	start int // where the last argument began, for unread
+}
+
+// next returns the next argument; ok is false at the end of the line
+func (a *argScanner) next() (text string, quoted, ok bool, err error) {
+	a.skipSpace()
+	if a.pos >= len(a.s) {
+		return "", false, false, nil
+	}
+	a.start = a.pos
+	var cur strings.Builder
+	var quote rune
+	escaped := false
+	for a.pos < len(a.s) {
+		r, size := utf8.DecodeRuneInString(a.s[a.pos:])
+		if quote == 0 && !escaped && unicode.IsSpace(r) {
+			break
+		}
+		a.pos += size
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, quoted = r, true
+		case r == '“':
+			quote, quoted = '”', true
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return "", false, false, fmt.Errorf("unterminated quote")
+	}
+	return cur.String(), quoted, true, nil
+}
+
+// unread puts the last argument back
+func (a *argScanner) unread() { a.pos = a.start }
+
+// peekFlag reports whether the next argument is an unquoted --flag
+func (a *argScanner) peekFlag() bool {
+	a.skipSpace()
+	return strings.HasPrefix(a.s[a.pos:], "--")
+}
+
+// peekQuoted reports whether the next argument starts with a quote
+func (a *argScanner) peekQuoted() bool {
+	a.skipSpace()
+	r, _ := utf8.DecodeRuneInString(a.s[a.pos:])
+	return r == '"' || r == '\'' || r == '“'
+}
+
+// rest returns the remainder of the line as it was typed, line breaks included
+func (a *argScanner) rest() string {
+	return strings.TrimSpace(a.s[a.pos:])
+}
+
+func (a *argScanner) skipSpace() {
+	for a.pos < len(a.s) {
+		r, size := utf8.DecodeRuneInString(a.s[a.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		a.pos += size
+	}
+}
+
+// splitLeadingArgs reads up to n arguments, or all of them when n is
+// negative, and returns them with the rest of the line as typed
+func splitLeadingArgs(s string, n int) ([]string, string, error) {
+	a := &argScanner{s: s}
+	var args []string
+	for n < 0 || len(args) < n {
+		text, _, ok, err := a.next()
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			break
+		}
+		args = append(args, text)
+	}
+	return args, a.rest(), nil
+}
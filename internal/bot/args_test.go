@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{`  a  b	c `, []string{"a", "b", "c"}},
+		{`name=work password="pa ss" host='smtp.example.com'`, []string{"name=work", "password=pa ss", "host=smtp.example.com"}},
+		{`say “hello there” now`, []string{"say", "hello there", "now"}},
+		{`a\ b c\"d ""`, []string{"a b", `c"d`, ""}},
+		{"one\ntwo", []string{"one", "two"}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := splitArgs(`a "b`); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
+
+func TestSplitLeadingArgsKeepsRest(t *testing.T) {
+	args, rest, err := splitLeadingArgs("weekly \"Status \\\"W1\\\"\"  Line one\n  line two ", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"weekly", `Status "W1"`}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+	if want := "Line one\n  line two"; rest != want {
+		t.Errorf("rest = %q, want %q", rest, want)
+	}
+}
+
+func TestCmdSendRejectsNoRecipients(t *testing.T) {
+	b, tg := newTestBot(t)
+	text := `/send ", ," "Hi" Hello`
+	b.cmdSend(&tgbotapi.Message{
+		Chat:     &tgbotapi.Chat{ID: 5},
+		Text:     text,
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/send")}},
+	})
+	sent := tg.sent("sendMessage")
+	if len(sent) != 1 || !strings.Contains(sent[0].Params["text"], "No recipient") {
+		t.Fatalf("replies = %+v, want one saying there is no recipient", sent)
+	}
+	var n int
+	b.db.QueryRow("SELECT COUNT(*) FROM scheduled_emails").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d emails queued without a recipient", n)
+	}
+}
+
+func TestParseSend(t *testing.T) {
+	tests := []struct {
+		in   string
+		want sendRequest
+	}{
+		{`a@b.c "Hi" Hello there`, sendRequest{To: "a@b.c", Subject: "Hi", Body: "Hello there"}},
+		{`a@b.c --cc d@e.f "Hi" --at 2h Hello`, sendRequest{To: "a@b.c", Cc: "d@e.f", Subject: "Hi", At: "2h", Body: "Hello"}},
+		{`a@b.c --at 2026-11-02 09:00 "Hi" Hello`, sendRequest{To: "a@b.c", Subject: "Hi", At: "2026-11-02 09:00", Body: "Hello"}},
+		{`a@b.c --template weekly All quiet`, sendRequest{To: "a@b.c", Template: "weekly", Body: "All quiet"}},
+		// a body starting with dashes is not taken for a flag
+		{`a@b.c "Hi" --- notes`, sendRequest{To: "a@b.c", Subject: "Hi", Body: "--- notes"}},
+		{`a@b.c "Hi" --verbose is my style`, sendRequest{To: "a@b.c", Subject: "Hi", Body: "--verbose is my style"}},
+		{`a@b.c --template weekly --- notes`, sendRequest{To: "a@b.c", Template: "weekly", Body: "--- notes"}},
+		// -- ends the flags, even known ones
+		{`a@b.c "Hi" -- --cc is a flag`, sendRequest{To: "a@b.c", Subject: "Hi", Body: "--cc is a flag"}},
+		{`a@b.c -- "--at" Hello`, sendRequest{To: "a@b.c", Subject: "--at", Body: "Hello"}},
+	}
+	for _, tt := range tests {
+		got, err := parseSend(tt.in)
+		if err != nil {
+			t.Errorf("parseSend(%q): %v", tt.in, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseSend(%q) = %+v, want %+v", tt.in, *got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`a@b.c --bcc x@y.z "Hi" Hello`, `a@b.c "Hi" --cc`} {
+		if _, err := parseSend(in); err == nil {
+			t.Errorf("parseSend(%q) succeeded, want an error", in)
+		}
+	}
+}
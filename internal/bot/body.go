@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The body step collects every message sent until /done, so long texts can be
+// written in several messages. Parts are joined by a blank line.
+
+const bodyPartSeparator = "\n\n"
+
+// joinedBody is the body collected so far, without the reply quote
+func joinedBody(s *EmailSession) string {
+	return strings.Join(s.BodyParts, bodyPartSeparator)
+}
+
+// addBodyPart appends one message to the body being collected
+func (b *Bot) addBodyPart(chatID int64, session *EmailSession, text string) {
+	if text == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Please send the body as text, or /done to finish."))
+		return
+	}
+	session.BodyParts = append(session.BodyParts, text)
+	b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"➕ Part %d added, %d characters so far. Send more, /undo to remove the last part or /done to finish.",
+		len(session.BodyParts), utf8.RuneCountInString(joinedBody(session)))))
+}
+
+// cmdDone finishes the body, or the attachment step when no more files follow
+func (b *Bot) cmdDone(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	session, ok := b.getSession(chatID)
+	if !ok {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No active session. Use /sendmail to start."))
+		return
+	}
+	switch session.Step {
+	case stepBody:
+		if len(session.BodyParts) == 0 {
+			b.API.Send(tgbotapi.NewMessage(chatID, "The body is still empty. Send some text first."))
+			return
+		}
+		session.Body = joinedBody(session) + session.Quote
+		session.BodyParts = nil
+		if session.Editing {
+			b.sendPreview(chatID, session)
+			return
+		}
+		session.Step = stepAttachAsk
+		m := tgbotapi.NewMessage(chatID, fmt.Sprintf(
+			"✅ Body done (%d characters).\n📎 Do you want to attach a file? Reply `yes` to attach or `no` to skip.",
+			utf8.RuneCountInString(session.Body)))
+		m.ReplyMarkup = attachKeyboard
+		b.sendKeyboard(session, m)
+	case stepUpload:
+		b.sendPreview(chatID, session)
+	default:
+		b.API.Send(tgbotapi.NewMessage(chatID, "Nothing to finish at this step."))
+	}
+}
+
+// cmdUndo removes the last message added to the body
+func (b *Bot) cmdUndo(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	session, ok := b.getSession(chatID)
+	if !ok || session.Step != stepBody {
+		b.API.Send(tgbotapi.NewMessage(chatID, "/undo only works while writing the body."))
+		return
+	}
+	if len(session.BodyParts) == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "The body is already empty."))
+		return
+	}
+	session.BodyParts = session.BodyParts[:len(session.BodyParts)-1]
+	b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"↩️ Removed the last part, %d part(s) and %d characters left.",
+		len(session.BodyParts), utf8.RuneCountInString(joinedBody(session)))))
+}
@@ -1,17 +1,15 @@
 package bot
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/smtp"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,40 +18,127 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/scan"
 )
 
 const (
 	attachmentsDir = "attachments"
 	sqliteFile     = "botdata.db"
 	pollInterval   = time.Minute
+	// smtpIdleTimeout closes pooled SMTP connections nobody has used for a while
+	smtpIdleTimeout = 30 * time.Second
+	// previewBodyLen bounds the body shown in the preview so it fits one message
+	previewBodyLen = 1000
 )
 
-// EmailSession stores temporary email composition data per chat
+// composeStep is where a chat is in the compose wizard
+type composeStep int
+
+const (
+	stepTo         composeStep = iota + 1 // recipients
+	stepSubject                           // subject
+	stepBody                              // body, one part per message until /done
+	stepAttachAsk                         // attach a file?
+	stepUpload                            // waiting for files
+	stepPreview                           // preview: send, schedule or change the email
+	stepPlaceImage                        // inline or attach an image sent with an HTML body
+	stepSendTime                          // send time chosen from the preview
+)
+
+// EmailSession stores temporary email composition data per chat. A session is
+// only read and changed by its chat's update handler, which the dispatcher runs
+// one update at a time, so its fields need no lock of their own.
 type EmailSession struct {
-	Step      int
+	Step      composeStep
 	To        string
+	Cc        string
 	Subject   string
 	Body      string
-	FilePath  string
+	FileHash  string
 	FileName  string
+	FileType  string
 	Schedule  string
 	ChatID    int64
+	AccountID int64
+	Encrypt   bool
 	CreatedAt time.Time
+
+	// BodyParts are the messages collected at the body step until /done
+	BodyParts []string
+
+	// set when replying to an email: threading headers and the quoted
+	// original, which is appended to the body
+	InReplyTo  string
+	References []string
+	Quote      string
+
+	// Inline holds photos embedded in an HTML body; Pending is an uploaded
+	// photo waiting for the inline-or-attach choice
+	Inline  []inlineImage
+	Pending *inlineImage
+
+	// AsLink sends the attachment as a download link hosted by the bot
+	AsLink bool
+
+	// Editing is set while one field is re-entered from the preview; the
+	// wizard returns to the preview once it is done
+	Editing bool
+
+	// Prefilled sessions come from a forwarded message: subject, body and
+	// attachment are set, so only the recipient is asked for
+	Prefilled bool
+
+	// KeyboardMsgID is the message with the wizard's live buttons; buttons
+	// on any other message are stale
+	KeyboardMsgID int
 }
 
 // Bot is the main bot struct
 type Bot struct {
-	API      *tgbotapi.BotAPI
-	SMTPHost string
-	SMTPPort int
-	Username string
-	Password string
+	API *tgbotapi.BotAPI
+
+	defaultAccount *Account
+	secrets        *SecretBox
 
+	// sessionsMu guards the map; each session belongs to its chat's handler
 	sessions   map[int64]*EmailSession
 	sessionsMu sync.RWMutex
 
+	// updateWorkers bounds how many chats' updates are handled at once
+	updateWorkers int
+
+	// scheduleWake makes the scheduled worker run before its next tick
+	scheduleWake chan struct{}
+
 	db   *sql.DB
 	dbMu sync.Mutex
+
+	tokens   map[int64]*mail.TokenSource
+	tokensMu sync.Mutex
+
+	smtpPool *mail.Pool
+	// sendCtx is cancelled when shutdown stops waiting for in-flight deliveries
+	sendCtx    context.Context
+	abortSends context.CancelFunc
+
+	attachmentRetention time.Duration
+
+	// HTTP server for download links
+	httpAddr         string
+	publicURL        string
+	mux              *http.ServeMux
+	linkTTL          time.Duration
+	linkMaxDownloads int
+
+	// scanner checks uploads for malware before they are accepted
+	scanner scan.Scanner
+
+	// webhook mode: Telegram posts updates to webhookURL on the HTTP server;
+	// nil means long polling
+	webhookURL     *url.URL
+	webhookSecret  string
+	webhookUpdates chan tgbotapi.Update
 }
 
 // NewBotFromEnv loads env and initializes the bot
@@ -75,10 +160,130 @@ func NewBotFromEnv() (*Bot, error) {
 	}
 	smtpPort, _ := strconv.Atoi(smtpPortStr)
 
-	username := os.Getenv("GMAIL_USERNAME")
-	password := os.Getenv("GMAIL_PASSWORD")
-	if username == "" || password == "" {
-		return nil, fmt.Errorf("GMAIL_USERNAME and GMAIL_PASSWORD are required")
+	tlsMode, err := mail.ParseTLSMode(os.Getenv("SMTP_TLS_MODE"))
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_TLS_MODE: %w", err)
+	}
+	imapTLSMode, err := mail.ParseTLSMode(os.Getenv("IMAP_TLS_MODE"))
+	if err != nil {
+		return nil, fmt.Errorf("IMAP_TLS_MODE: %w", err)
+	}
+	imapPort, _ := strconv.Atoi(os.Getenv("IMAP_PORT"))
+	imapChatID, _ := strconv.ParseInt(os.Getenv("IMAP_CHAT_ID"), 10, 64)
+	maxSizeMB, _ := strconv.Atoi(os.Getenv("SMTP_MAX_SIZE_MB"))
+
+	linkTTL := defaultLinkTTL
+	if v := os.Getenv("DOWNLOAD_LINK_TTL"); v != "" {
+		if linkTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("DOWNLOAD_LINK_TTL: %w", err)
+		}
+	}
+	linkMaxDownloads, _ := strconv.Atoi(os.Getenv("DOWNLOAD_LINK_MAX_DOWNLOADS"))
+
+	retention := defaultAttachmentRetention
+	if v := os.Getenv("ATTACHMENT_RETENTION"); v != "" {
+		if retention, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("ATTACHMENT_RETENTION: %w", err)
+		}
+	}
+
+	updateWorkers := defaultUpdateWorkers
+	if v := os.Getenv("UPDATE_WORKERS"); v != "" {
+		if updateWorkers, err = strconv.Atoi(v); err != nil || updateWorkers < 1 {
+			return nil, fmt.Errorf("UPDATE_WORKERS %q: want a positive number", v)
+		}
+	}
+
+	var webhookURL *url.URL
+	var webhookSecret string
+	switch mode := strings.ToLower(os.Getenv("BOT_MODE")); mode {
+	case "", "polling":
+	case "webhook":
+		if webhookURL, webhookSecret, err = webhookConfig(os.Getenv("WEBHOOK_URL"), os.Getenv("PUBLIC_URL"), os.Getenv("WEBHOOK_SECRET")); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("BOT_MODE %q: use polling or webhook", mode)
+	}
+
+	var scanner scan.Scanner = scan.Nop{}
+	if addr := os.Getenv("CLAMD_ADDR"); addr != "" {
+		clamd, err := scan.NewClamd(addr)
+		if err != nil {
+			return nil, fmt.Errorf("CLAMD_ADDR: %w", err)
+		}
+		scanner = clamd
+	}
+
+	defaultAccount := &Account{
+		ChatID:        imapChatID,
+		Name:          "default",
+		Host:          smtpHost,
+		Port:          smtpPort,
+		Username:      os.Getenv("GMAIL_USERNAME"),
+		Password:      os.Getenv("GMAIL_PASSWORD"),
+		Auth:          strings.ToLower(os.Getenv("SMTP_AUTH")),
+		TokenURL:      os.Getenv("OAUTH_TOKEN_URL"),
+		ClientID:      os.Getenv("OAUTH_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OAUTH_CLIENT_SECRET"),
+		RefreshToken:  os.Getenv("OAUTH_REFRESH_TOKEN"),
+		Scope:         os.Getenv("OAUTH_SCOPE"),
+		TLSMode:       tlsMode,
+		CAFile:        os.Getenv("SMTP_CA_FILE"),
+		ServerName:    os.Getenv("SMTP_SERVER_NAME"),
+		DKIMDomain:    os.Getenv("DKIM_DOMAIN"),
+		DKIMSelector:  os.Getenv("DKIM_SELECTOR"),
+		DKIMKeyFile:   os.Getenv("DKIM_KEY_FILE"),
+		PGPKeyFile:    os.Getenv("PGP_KEY_FILE"),
+		PGPPassphrase: os.Getenv("PGP_PASSPHRASE"),
+		SMIMEFile:     os.Getenv("SMIME_P12_FILE"),
+		SMIMEPassword: os.Getenv("SMIME_P12_PASSWORD"),
+		IMAPHost:      os.Getenv("IMAP_HOST"),
+		IMAPPort:      imapPort,
+		IMAPFolder:    os.Getenv("IMAP_FOLDER"),
+		IMAPTLS:       imapTLSMode,
+		MaxSizeMB:     maxSizeMB,
+	}
+
+	if defaultAccount.Username == "" {
+		return nil, fmt.Errorf("GMAIL_USERNAME is required")
+	}
+	if _, err := defaultAccount.dkimSigner(); err != nil {
+		return nil, err
+	}
+	if _, err := defaultAccount.pgpSigner(); err != nil {
+		return nil, err
+	}
+	if _, err := defaultAccount.smimeSigner(); err != nil {
+		return nil, err
+	}
+	if defaultAccount.IMAPHost != "" && imapChatID == 0 {
+		return nil, fmt.Errorf("IMAP_CHAT_ID is required when IMAP_HOST is set")
+	}
+	switch defaultAccount.Auth {
+	case "none":
+		// local relay without authentication
+	case "xoauth2":
+		if defaultAccount.ClientID == "" || defaultAccount.RefreshToken == "" {
+			return nil, fmt.Errorf("OAUTH_CLIENT_ID and OAUTH_REFRESH_TOKEN are required when SMTP_AUTH=xoauth2")
+		}
+	default:
+		if defaultAccount.Password == "" {
+			return nil, fmt.Errorf("GMAIL_USERNAME and GMAIL_PASSWORD are required")
+		}
+	}
+
+	masterKey, err := LoadMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	var secrets *SecretBox
+	if masterKey != nil {
+		if secrets, err = NewSecretBox(masterKey); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Println("MASTER_KEY not set, /addaccount is disabled")
 	}
 
 	if err := os.MkdirAll(attachmentsDir, 0o755); err != nil {
@@ -98,18 +303,37 @@ func NewBotFromEnv() (*Bot, error) {
 		return nil, fmt.Errorf("init db: %w", err)
 	}
 
-	return &Bot{
-		API:      api,
-		SMTPHost: smtpHost,
-		SMTPPort: smtpPort,
-		Username: username,
-		Password: password,
-		sessions: make(map[int64]*EmailSession),
-		db:       db,
-	}, nil
+	b := &Bot{
+		API:                 api,
+		defaultAccount:      defaultAccount,
+		secrets:             secrets,
+		sessions:            make(map[int64]*EmailSession),
+		updateWorkers:       updateWorkers,
+		scheduleWake:        make(chan struct{}, 1),
+		db:                  db,
+		tokens:              make(map[int64]*mail.TokenSource),
+		smtpPool:            mail.NewPool(smtpIdleTimeout),
+		attachmentRetention: retention,
+		httpAddr:            os.Getenv("HTTP_ADDR"),
+		publicURL:           os.Getenv("PUBLIC_URL"),
+		mux:                 http.NewServeMux(),
+		linkTTL:             linkTTL,
+		linkMaxDownloads:    linkMaxDownloads,
+		scanner:             scanner,
+		webhookURL:          webhookURL,
+		webhookSecret:       webhookSecret,
+		webhookUpdates:      make(chan tgbotapi.Update, 100),
+	}
+	b.sendCtx, b.abortSends = context.WithCancel(context.Background())
+	b.mux.HandleFunc("/d/", b.serveDownload)
+	b.registerAPI()
+	if err := b.prepareAttachmentStore(); err != nil {
+		return nil, fmt.Errorf("attachment store: %w", err)
+	}
+	return b, nil
 }
 
-// initDB creates scheduled emails table
+// initDB creates the tables and applies column migrations
 func initDB(db *sql.DB) error {
 	create := `
 	CREATE TABLE IF NOT EXISTS scheduled_emails (
@@ -123,54 +347,271 @@ func initDB(db *sql.DB) error {
 		status TEXT,
 		created_at TEXT
 	);
+	CREATE TABLE IF NOT EXISTS smtp_accounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		name TEXT,
+		host TEXT,
+		port INTEGER,
+		username TEXT,
+		options_json TEXT,
+		secrets_enc TEXT,
+		active INTEGER DEFAULT 0,
+		created_at TEXT,
+		UNIQUE(chat_id, name)
+	);
+	CREATE TABLE IF NOT EXISTS pgp_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		email TEXT,
+		fingerprint TEXT,
+		public_key BLOB,
+		created_at TEXT,
+		UNIQUE(chat_id, email)
+	);
+	CREATE TABLE IF NOT EXISTS sent_emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		account_id INTEGER,
+		message_id TEXT,
+		references_list TEXT,
+		recipient TEXT,
+		subject TEXT,
+		sent_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS attachment_blobs (
+		sha256 TEXT PRIMARY KEY,
+		size INTEGER,
+		created_at TEXT,
+		touched_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS attachment_refs (
+		sha256 TEXT,
+		owner TEXT,
+		PRIMARY KEY(sha256, owner)
+	);
+	CREATE TABLE IF NOT EXISTS download_links (
+		token TEXT PRIMARY KEY,
+		sha256 TEXT,
+		name TEXT,
+		content_type TEXT,
+		expires_at TEXT,
+		max_downloads INTEGER,
+		downloads INTEGER,
+		created_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS imap_state (
+		account_id INTEGER,
+		folder TEXT,
+		uid_validity INTEGER,
+		last_uid INTEGER,
+		PRIMARY KEY(account_id, folder)
+	);
+	CREATE TABLE IF NOT EXISTS inbound_emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		account_id INTEGER,
+		folder TEXT,
+		uid_validity INTEGER,
+		uid INTEGER,
+		message_id TEXT,
+		references_list TEXT,
+		from_addr TEXT,
+		reply_to TEXT,
+		subject TEXT,
+		body TEXT,
+		tg_message_id INTEGER,
+		received_at TEXT,
+		UNIQUE(account_id, folder, uid_validity, uid)
+	);
+	CREATE TABLE IF NOT EXISTS templates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		name TEXT,
+		subject TEXT,
+		body TEXT,
+		created_at TEXT,
+		UNIQUE(chat_id, name)
+	);
+	CREATE TABLE IF NOT EXISTS drafts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		recipients TEXT,
+		subject TEXT,
+		session_json TEXT,
+		created_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		name TEXT,
+		key_hash TEXT UNIQUE,
+		created_at TEXT,
+		UNIQUE(chat_id, name)
+	);
 	`
-	_, err := db.Exec(create)
+	if _, err := db.Exec(create); err != nil {
+		return err
+	}
+	if err := migrate(db); err != nil {
+		return err
+	}
+	// an email claimed by a worker that then crashed is tried again
+	_, err := db.Exec("UPDATE scheduled_emails SET status = 'pending' WHERE status = 'sending'")
 	return err
 }
 
-// Start listens to Telegram updates
-func (b *Bot) Start() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates := b.API.GetUpdatesChan(u)
+// migrations add columns to tables created by older versions
+var migrations = []string{
+	`ALTER TABLE scheduled_emails ADD COLUMN account_id INTEGER DEFAULT 0`,
+	`ALTER TABLE scheduled_emails ADD COLUMN encrypt INTEGER DEFAULT 0`,
+	`ALTER TABLE scheduled_emails ADD COLUMN cc TEXT DEFAULT ''`,
+	`ALTER TABLE scheduled_emails ADD COLUMN in_reply_to TEXT DEFAULT ''`,
+	`ALTER TABLE scheduled_emails ADD COLUMN references_list TEXT DEFAULT ''`,
+	`ALTER TABLE scheduled_emails ADD COLUMN as_link INTEGER DEFAULT 0`,
+	`ALTER TABLE scheduled_emails ADD COLUMN error TEXT DEFAULT ''`,
+}
 
+func migrate(db *sql.DB) error {
+	for _, m := range migrations {
+		if _, err := db.Exec(m); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return fmt.Errorf("migrate %q: %w", m, err)
+		}
+	}
+	return nil
+}
+
+// Start listens to Telegram updates, from the webhook in webhook mode and by
+// long polling otherwise, until ctx is done. Each chat's updates are handled
+// in order while different chats are handled concurrently; updates already
+// received are handled before it returns.
+func (b *Bot) Start(ctx context.Context) {
 	log.Printf("authorized on account %s", b.API.Self.UserName)
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
-		msg := update.Message
+	d := newDispatcher(b.updateWorkers, b.handleUpdate)
+	defer d.wait()
 
-		if b.hasSession(msg.Chat.ID) && !msg.IsCommand() {
-			if msg.Document != nil || len(msg.Photo) > 0 {
-				b.handleAttachment(msg)
-			} else {
-				b.handleConversation(msg)
+	updates := b.updates()
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			d.submit(update)
+		case <-ctx.Done():
+			b.API.StopReceivingUpdates()
+			for {
+				select {
+				case update, ok := <-updates:
+					if !ok {
+						return
+					}
+					d.submit(update)
+				default:
+					return
+				}
 			}
-			continue
 		}
+	}
+}
 
-		if msg.IsCommand() {
-			switch msg.Command() {
-			case "start":
-				b.cmdStart(msg)
-			case "help":
-				b.cmdHelp(msg)
-			case "sendmail":
-				b.cmdSendMail(msg)
-			case "scheduled":
-				b.cmdListScheduled(msg)
-			case "cancel":
-				b.cmdCancelSession(msg)
-			default:
-				b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "Unknown command. Use /help"))
-			}
-			continue
+// handleUpdate dispatches one update from Telegram
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		b.handleCallback(update.CallbackQuery)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+	msg := update.Message
+
+	if b.hasSession(msg.Chat.ID) && !msg.IsCommand() {
+		if _, ok := messageMedia(msg); ok {
+			b.handleAttachment(msg)
+		} else {
+			b.handleConversation(msg)
 		}
+		return
+	}
 
-		b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "Hello! Use /sendmail to start composing an email."))
+	if msg.IsCommand() {
+		switch msg.Command() {
+		case "start":
+			b.cmdStart(msg)
+		case "help":
+			b.cmdHelp(msg)
+		case "sendmail":
+			b.cmdSendMail(msg)
+		case "send":
+			b.cmdSend(msg)
+		case "templates":
+			b.cmdListTemplates(msg)
+		case "addtemplate":
+			b.cmdAddTemplate(msg)
+		case "deltemplate":
+			b.cmdDeleteTemplate(msg)
+		case "scheduled":
+			b.cmdListScheduled(msg)
+		case "cancel":
+			b.cmdCancelSession(msg)
+		case "drafts":
+			b.cmdDrafts(msg)
+		case "done":
+			b.cmdDone(msg)
+		case "undo":
+			b.cmdUndo(msg)
+		case "addaccount":
+			b.cmdAddAccount(msg)
+		case "accounts":
+			b.cmdListAccounts(msg)
+		case "useaccount":
+			b.cmdUseAccount(msg)
+		case "delaccount":
+			b.cmdDeleteAccount(msg)
+		case "mail":
+			b.cmdMail(msg)
+		case "history":
+			b.cmdHistory(msg)
+		case "followup":
+			b.cmdFollowup(msg)
+		case "keys":
+			b.cmdListKeys(msg)
+		case "delkey":
+			b.cmdDeleteKey(msg)
+		case "apikeys":
+			b.cmdListAPIKeys(msg)
+		case "newapikey":
+			b.cmdNewAPIKey(msg)
+		case "delapikey":
+			b.cmdDeleteAPIKey(msg)
+		default:
+			b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "Unknown command. Use /help"))
+		}
+		return
 	}
+
+	if msg.ReplyToMessage != nil {
+		if in, ok := b.inboundByTelegramMessage(msg.Chat.ID, msg.ReplyToMessage.MessageID); ok {
+			b.startReply(msg, in)
+			return
+		}
+	}
+	if isAccountKeyUpload(msg) {
+		b.handleAccountKeyUpload(msg)
+		return
+	}
+	if msg.Document != nil && isPGPKeyFile(msg.Document) {
+		b.handlePGPKeyUpload(msg)
+		return
+	}
+	if isForwarded(msg) {
+		b.startForward(msg, "")
+		return
+	}
+
+	b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "Hello! Use /sendmail to start composing an email."))
 }
 
 // ---------- Commands ----------
@@ -188,8 +629,24 @@ func (b *Bot) cmdStart(msg *tgbotapi.Message) {
 func (b *Bot) cmdHelp(msg *tgbotapi.Message) {
 	text := "ℹ️ *Commands*\n\n" +
 		"/sendmail - start interactive email composer\n" +
+		"/send <to> \"<subject>\" <body> - send in one line; --cc <addr>, --at <YYYY-MM-DD HH:MM|2h>, --template <name>\n" +
+		"/templates - list templates; /addtemplate <name> \"<subject>\" <body> adds one, /deltemplate <name> deletes it\n" +
 		"/scheduled - list pending scheduled emails\n" +
-		"/cancel - cancel current compose session\n\n" +
+		"/cancel - cancel current compose session\n" +
+		"/drafts - list saved drafts to continue or delete them\n" +
+		"/done - finish the body (it can span several messages) or the attachments\n" +
+		"/undo - remove the last message added to the body\n" +
+		"/mail <address> - (as a reply) email that message; forwarding a message to me works too\n" +
+		"/history - list recently sent emails\n" +
+		"/followup <id> - write a follow-up in the same thread as a sent email\n" +
+		"/accounts - list SMTP accounts\n" +
+		"/addaccount name=.. host=.. port=.. user=.. password=.. - add an SMTP account\n" +
+		"/useaccount <name|default> - choose the account to send from\n" +
+		"/delaccount <name> - delete an SMTP account\n" +
+		"/keys - list stored PGP public keys (send an .asc file to add one)\n" +
+		"/delkey <email> - delete a PGP public key\n" +
+		"/apikeys - list API keys for the HTTP API; /newapikey <name> creates one, /delapikey <name> deletes it\n\n" +
+		"Reply to a forwarded 📥 email to answer it; the reply is threaded and quotes the original.\n\n" +
 		"Interactive flow will ask: recipient(s), subject, body, attachment (optional), schedule (now or `YYYY-MM-DD HH:MM`)."
 	m := tgbotapi.NewMessage(msg.Chat.ID, text)
 	m.ParseMode = "Markdown"
@@ -198,8 +655,9 @@ func (b *Bot) cmdHelp(msg *tgbotapi.Message) {
 
 func (b *Bot) cmdSendMail(msg *tgbotapi.Message) {
 	s := &EmailSession{
-		Step:      1,
+		Step:      stepTo,
 		ChatID:    msg.Chat.ID,
+		AccountID: b.activeAccountID(msg.Chat.ID),
 		CreatedAt: time.Now().UTC(),
 	}
 	b.setSession(msg.Chat.ID, s)
@@ -239,10 +697,19 @@ func (b *Bot) cmdListScheduled(msg *tgbotapi.Message) {
 
 // ---------- Session helpers ----------
 
+// setSession makes s the chat's session; the attachments of a session it
+// replaces are released
 func (b *Bot) setSession(chatID int64, s *EmailSession) {
 	b.sessionsMu.Lock()
-	defer b.sessionsMu.Unlock()
+	old, ok := b.sessions[chatID]
 	b.sessions[chatID] = s
+	b.sessionsMu.Unlock()
+	if ok && old != s {
+		b.releaseAttachments(sessionOwner(chatID))
+	}
+	for _, h := range sessionHashes(s) {
+		b.retainAttachment(h, sessionOwner(chatID))
+	}
 }
 
 func (b *Bot) getSession(chatID int64) (*EmailSession, bool) {
@@ -254,8 +721,9 @@ func (b *Bot) getSession(chatID int64) (*EmailSession, bool) {
 
 func (b *Bot) deleteSession(chatID int64) {
 	b.sessionsMu.Lock()
-	defer b.sessionsMu.Unlock()
 	delete(b.sessions, chatID)
+	b.sessionsMu.Unlock()
+	b.releaseAttachments(sessionOwner(chatID))
 }
 
 func (b *Bot) hasSession(chatID int64) bool {
@@ -278,144 +746,366 @@ func (b *Bot) handleConversation(msg *tgbotapi.Message) {
 	lower := strings.ToLower(text)
 
 	switch session.Step {
-	case 1: // recipients
+	case stepTo:
 		session.To = text
-		session.Step = 2
+		if session.Prefilled || session.Editing {
+			b.sendPreview(chatID, session)
+			return
+		}
+		session.Step = stepSubject
 		b.API.Send(tgbotapi.NewMessage(chatID, "✏️ Subject?"))
-	case 2: // subject
+	case stepSubject:
 		session.Subject = text
-		session.Step = 3
+		if session.Editing {
+			b.sendPreview(chatID, session)
+			return
+		}
+		session.Step = stepBody
 		b.API.Send(tgbotapi.NewMessage(chatID, "📝 Body text (send a single message or multiple; type /done to finish):"))
-	case 3: // body
-		session.Body = text
-		session.Step = 4
-		b.API.Send(tgbotapi.NewMessage(chatID, "📎 Do you want to attach a file? Reply `yes` to attach or `no` to skip."))
-	case 4:
+	case stepBody:
+		b.addBodyPart(chatID, session, text)
+	case stepAttachAsk:
 		if lower == "yes" {
-			session.Step = 5
-			b.API.Send(tgbotapi.NewMessage(chatID, "📂 Please upload the file now (send as document)."))
+			b.chooseAttach(chatID, session, true)
 		} else if lower == "no" {
-			session.Step = 6
-			b.sendPreview(chatID, session)
+			b.chooseAttach(chatID, session, false)
 		} else {
 			b.API.Send(tgbotapi.NewMessage(chatID, "Please reply with `yes` or `no`."))
 		}
-	case 5:
-		b.API.Send(tgbotapi.NewMessage(chatID, "Waiting for file upload. Send document or type `skip`."))
-		if lower == "skip" {
-			session.Step = 6
+	case stepUpload:
+		if lower == "skip" || lower == "done" {
 			b.sendPreview(chatID, session)
-		}
-	case 6:
-		if lower == "now" || lower == "send now" || lower == "send" {
-			b.API.Send(tgbotapi.NewMessage(chatID, "📤 Sending now..."))
-			if err := b.sendMailMulti(session); err != nil {
-				b.API.Send(tgbotapi.NewMessage(chatID, "Failed to send: "+err.Error()))
-			} else {
-				b.API.Send(tgbotapi.NewMessage(chatID, "✅ Email sent!"))
-			}
-			b.deleteSession(chatID)
 		} else {
-			session.Schedule = text
-			if err := b.schedulePersist(session); err != nil {
-				b.API.Send(tgbotapi.NewMessage(chatID, "Failed to schedule: "+err.Error()))
-			} else {
-				b.API.Send(tgbotapi.NewMessage(chatID, "⏰ Email scheduled successfully!"))
-			}
-			b.deleteSession(chatID)
+			b.API.Send(tgbotapi.NewMessage(chatID, "Waiting for file upload. Send a file or type `skip`."))
+		}
+	case stepPreview:
+		switch lower {
+		case "encrypt":
+			b.toggleEncryption(chatID, session)
+		case "zip":
+			b.zipAttachment(chatID, session)
+		case "link":
+			b.toggleLink(chatID, session)
+		case "draft", "save draft":
+			b.saveDraft(chatID, session)
+		case "now", "send now", "send":
+			b.sendNow(chatID, session)
+		default:
+			b.scheduleAt(chatID, session, text)
+		}
+	case stepSendTime:
+		b.setSchedule(chatID, session, text)
+	case stepPlaceImage:
+		switch {
+		case session.Pending == nil:
+			session.Step = stepUpload
+		case lower == "inline":
+			b.placeImage(chatID, session, true)
+		case lower == "attach":
+			b.placeImage(chatID, session, false)
+		default:
+			b.API.Send(tgbotapi.NewMessage(chatID, "Please reply with `inline` or `attach`."))
 		}
 	default:
 		b.API.Send(tgbotapi.NewMessage(chatID, "Unknown session state. Use /cancel and try again."))
 	}
 }
 
+// chooseAttach answers the "attach a file?" question
+func (b *Bot) chooseAttach(chatID int64, session *EmailSession, attach bool) {
+	if !attach {
+		b.sendPreview(chatID, session)
+		return
+	}
+	session.Step = stepUpload
+	m := tgbotapi.NewMessage(chatID, "📂 Please upload the file now: a document, photo, voice note, audio, video or sticker (up to 20 MB).")
+	m.ReplyMarkup = uploadKeyboard
+	b.sendKeyboard(session, m)
+}
+
+// placeImage embeds the pending photo in the HTML body or attaches it as a file
+func (b *Bot) placeImage(chatID int64, session *EmailSession, inline bool) {
+	img := session.Pending
+	session.Pending = nil
+	if !inline {
+		session.FileHash, session.FileName, session.FileType = img.Hash, img.Name, img.Type
+		b.sendPreview(chatID, session)
+		return
+	}
+	img.ContentID = fmt.Sprintf("image%d", len(session.Inline)+1)
+	session.Inline = append(session.Inline, *img)
+	session.Step = stepUpload
+	m := tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"🖼 Embedded as cid:%s. Put <img src=\"cid:%s\"> in your HTML to place it, otherwise it is shown at the end.\nSend another photo, a file to attach, or type `done`.",
+		img.ContentID, img.ContentID))
+	m.ReplyMarkup = uploadKeyboard
+	b.sendKeyboard(session, m)
+}
+
+// sendNow sends the session's email and ends the session
+func (b *Bot) sendNow(chatID int64, session *EmailSession) {
+	if err := b.checkSessionSize(session); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "⛔ Not sent: "+err.Error()+". Type `zip` to compress the attachment or /cancel."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "📤 Sending now..."))
+	if err := b.sendMailMulti(b.sendCtx, session); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to send: "+err.Error()))
+	} else {
+		b.API.Send(tgbotapi.NewMessage(chatID, "✅ Email sent!"))
+	}
+	b.deleteSession(chatID)
+}
+
+// scheduleAt schedules the session's email for when (YYYY-MM-DD HH:MM) and
+// ends the session; a time that can't be used is asked for again
+func (b *Bot) scheduleAt(chatID int64, session *EmailSession, when string) {
+	if problem := scheduleTimeProblem(when); problem != "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, problem+" Or type `now` to send immediately."))
+		return
+	}
+	if err := b.checkSessionSize(session); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "⛔ Not scheduled: "+err.Error()+". Type `zip` to compress the attachment or /cancel."))
+		return
+	}
+	session.Schedule = when
+	if _, err := b.schedulePersist(session); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to schedule: "+err.Error()))
+	} else {
+		b.API.Send(tgbotapi.NewMessage(chatID, "⏰ Email scheduled for "+when+"!"))
+	}
+	b.deleteSession(chatID)
+}
+
 // sendPreview shows summary before asking schedule/send
 func (b *Bot) sendPreview(chatID int64, session *EmailSession) {
 	attach := "No"
 	if session.FileName != "" {
 		attach = session.FileName
+		if session.AsLink {
+			attach += " (as download link)"
+		}
+	}
+	encryption := "Off"
+	if session.Encrypt {
+		encryption = "On (PGP)"
+	}
+	format := "Plain text"
+	if looksLikeHTML(session.Body) {
+		format = fmt.Sprintf("HTML, %d inline image(s)", len(session.Inline))
+	}
+	when := "Now"
+	if session.Schedule != "" {
+		when = session.Schedule + " UTC"
 	}
+	to := session.To
+	if session.Cc != "" {
+		to += "\nCc: " + session.Cc
+	}
+	body := session.Body
+	if r := []rune(body); len(r) > previewBodyLen {
+		body = string(r[:previewBodyLen]) + "…"
+	}
+	// what the user typed may hold *, _ or ` that would break the Markdown
+	esc := func(s string) string { return tgbotapi.EscapeText(tgbotapi.ModeMarkdown, s) }
 	preview := fmt.Sprintf(
-		"📬 *Preview*\nTo: %s\nSubject: %s\nBody: %s\nAttachment: %s\n\nType `now` to send immediately or provide time `YYYY-MM-DD HH:MM` to schedule.",
-		session.To, session.Subject, session.Body, attach,
+		"📬 *Preview*\nTo: %s\nSubject: %s\nBody: %s\nFormat: %s\nAttachment: %s\nEncryption: %s\nWhen: %s\n\nType `now` to send immediately or provide time `YYYY-MM-DD HH:MM` to schedule. Type `encrypt` to toggle encryption.",
+		esc(to), esc(session.Subject), esc(body), format, esc(attach), encryption, when,
 	)
+	if len(sessionHashes(session)) > 0 {
+		preview += " Type `zip` to compress the attachments into one archive."
+	}
+	if session.FileHash != "" && b.linksEnabled() {
+		preview += " Type `link` to send the attachment as a download link instead."
+	}
+	if warning := b.sizeWarning(session); warning != "" {
+		preview += "\n\n" + warning
+	}
 	msg := tgbotapi.NewMessage(chatID, preview)
 	msg.ParseMode = "Markdown"
-	b.API.Send(msg)
-	session.Step = 6
+	msg.ReplyMarkup = b.previewKeyboard(session)
+	if _, err := b.sendKeyboard(session, msg); err != nil {
+		// still offer the buttons so the wizard can go on
+		log.Printf("send preview to chat %d: %v", chatID, err)
+		fallback := tgbotapi.NewMessage(chatID, "📬 The preview could not be shown. Type now to send, a time YYYY-MM-DD HH:MM to schedule, or /cancel.")
+		fallback.ReplyMarkup = b.previewKeyboard(session)
+		b.sendKeyboard(session, fallback)
+	}
+	session.Step = stepPreview
+	session.Editing = false
 }
 
 // ---------- Mail sending ----------
 
-func (b *Bot) sendMailMulti(session *EmailSession) error {
-	toList := strings.Split(session.To, ",")
-	for _, t := range toList {
-		if err := b.sendMail(strings.TrimSpace(t), session.Subject, session.Body, session.FilePath, session.FileName); err != nil {
+func (b *Bot) sendMailMulti(ctx context.Context, session *EmailSession) error {
+	acct, err := b.accountByID(session.AccountID)
+	if err != nil {
+		return err
+	}
+	if err := b.checkSize(acct, session); err != nil {
+		return err
+	}
+	if session.Encrypt {
+		if missing := b.missingPGPKeys(session.ChatID, allRecipients(session)); len(missing) > 0 {
+			return fmt.Errorf("encryption requested but no PGP key for %s; nothing was sent", strings.Join(missing, ", "))
+		}
+	}
+	session, link, err := b.withAttachmentLink(session)
+	if err != nil {
+		return err
+	}
+	for i, t := range splitRecipients(session.To) {
+		if err := b.sendMail(ctx, acct, t, copyTo(i, session), session); err != nil {
+			if i == 0 && link != "" {
+				// nobody got the link
+				b.deleteDownloadLink(link)
+			}
 			return err
 		}
 	}
 	return nil
 }
 
-// sendMail sends email with optional attachment
-func (b *Bot) sendMail(to, subject, body, filePath, filename string) error {
-	from := b.Username
-	msg := bytes.Buffer{}
-	boundary := "BOUNDARY-12345"
+// allRecipients lists the To and Cc addresses of the session
+func allRecipients(session *EmailSession) []string {
+	return append(splitRecipients(session.To), splitRecipients(session.Cc)...)
+}
 
-	msg.WriteString(fmt.Sprintf("From: %s\r\n", from))
-	msg.WriteString(fmt.Sprintf("To: %s\r\n", to))
-	msg.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
-	msg.WriteString("MIME-Version: 1.0\r\n")
+// copyTo lists the Cc addresses to add to the envelope of the i-th To
+// recipient's message. Every message names them in its Cc header, but only the
+// first is delivered to them so they get a single copy.
+func copyTo(i int, session *EmailSession) []string {
+	if i > 0 {
+		return nil
+	}
+	return splitRecipients(session.Cc)
+}
 
-	if filePath != "" {
-		msg.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%s\r\n\r\n", boundary))
-		msg.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
-		msg.WriteString(body + "\r\n\r\n")
+// splitRecipients splits a comma separated recipient list, dropping empty entries
+func splitRecipients(s string) []string {
+	var out []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// sendMail sends the session's email to one recipient from the given account
+func (b *Bot) sendMail(ctx context.Context, acct *Account, to string, cc []string, session *EmailSession) error {
+	m := &mail.Message{
+		From:    acct.Username,
+		To:      []string{to},
+		Cc:      splitRecipients(session.Cc),
+		Subject: session.Subject,
+		Body:    session.Body,
 
-		content, err := os.ReadFile(filePath)
+		MessageID:  mail.NewMessageID(acct.Username),
+		InReplyTo:  session.InReplyTo,
+		References: session.References,
+	}
+	if looksLikeHTML(session.Body) {
+		m.HTML, m.Body = session.Body, ""
+		for _, img := range session.Inline {
+			content, err := b.readAttachment(img.Hash)
+			if err != nil {
+				return err
+			}
+			m.Inline = append(m.Inline, mail.Attachment{Name: img.Name, ContentType: img.Type, Data: content, ContentID: img.ContentID})
+		}
+	}
+	if session.FileHash != "" {
+		content, err := b.readAttachment(session.FileHash)
 		if err != nil {
 			return err
 		}
-		msg.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-		msg.WriteString("Content-Type: application/octet-stream\r\n")
-		msg.WriteString("Content-Transfer-Encoding: base64\r\n")
-		msg.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n\r\n", filename))
-		msg.WriteString(base64.StdEncoding.EncodeToString(content))
-		msg.WriteString(fmt.Sprintf("\r\n--%s--\r\n", boundary))
-	} else {
-		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
-		msg.WriteString(body)
+		m.Attachments = append(m.Attachments, mail.Attachment{Name: session.FileName, ContentType: session.FileType, Data: content})
+	}
+	rcpts := append([]string{to}, cc...)
+	if err := b.applyPGP(m, acct, session, rcpts); err != nil {
+		return err
+	}
+	signer, err := acct.smimeSigner()
+	if err != nil {
+		return err
+	}
+	m.SMIMESigner = signer
+
+	raw, err := m.Bytes()
+	if err != nil {
+		return fmt.Errorf("build message: %w", err)
+	}
+	if signer, err := acct.dkimSigner(); err != nil {
+		return err
+	} else if signer != nil {
+		if raw, err = signer.Sign(raw); err != nil {
+			return err
+		}
 	}
 
-	auth := smtp.PlainAuth("", b.Username, b.Password, b.SMTPHost)
-	addr := fmt.Sprintf("%s:%d", b.SMTPHost, b.SMTPPort)
-	return smtp.SendMail(addr, auth, from, []string{to}, msg.Bytes())
+	if err := b.smtpPool.Send(ctx, acct.poolKey(), acct.dialConfig(), b.smtpAuth(acct), acct.Username, rcpts, raw); err != nil {
+		return err
+	}
+	b.recordSent(session, to, m.MessageID)
+	return nil
 }
 
 // ---------- Scheduling ----------
 
-func (b *Bot) schedulePersist(session *EmailSession) error {
-	b.dbMu.Lock()
-	defer b.dbMu.Unlock()
-
+// schedulePersist stores the session as a pending scheduled email and returns its id
+func (b *Bot) schedulePersist(session *EmailSession) (int64, error) {
 	attJSON := "[]"
+	arr := []map[string]string{}
 	if session.FileName != "" {
-		arr := []map[string]string{{"name": session.FileName, "path": session.FilePath}}
+		arr = append(arr, map[string]string{"name": session.FileName, "sha256": session.FileHash, "type": session.FileType})
+	}
+	for _, img := range session.Inline {
+		arr = append(arr, map[string]string{"name": img.Name, "sha256": img.Hash, "type": img.Type, "cid": img.ContentID})
+	}
+	if len(arr) > 0 {
 		j, _ := json.Marshal(arr)
 		attJSON = string(j)
 	}
 
-	_, err := b.db.Exec(`INSERT INTO scheduled_emails 
-	(chat_id, recipients, subject, body, attachments_json, send_at, status, created_at) 
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		session.ChatID, session.To, session.Subject, session.Body, attJSON, session.Schedule, "pending", time.Now().UTC().Format(time.RFC3339))
-	return err
+	b.dbMu.Lock()
+	res, err := b.db.Exec(`INSERT INTO scheduled_emails 
+	(chat_id, account_id, encrypt, as_link, in_reply_to, references_list, recipients, cc, subject, body, attachments_json, send_at, status, created_at) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ChatID, session.AccountID, session.Encrypt, session.AsLink, session.InReplyTo, strings.Join(session.References, " "),
+		session.To, session.Cc, session.Subject, session.Body, attJSON, session.Schedule, "pending", time.Now().UTC().Format(time.RFC3339))
+	b.dbMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	for _, h := range sessionHashes(session) {
+		b.retainAttachment(h, scheduledOwner(id))
+	}
+	return id, nil
 }
 
 // ---------- Attachment ----------
 
+// downloadFile fetches a Telegram file into memory
+func (b *Bot) downloadFile(fileID string) ([]byte, error) {
+	url, err := b.API.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// handleAttachment stores the file, photo, voice note, audio, video or sticker
+// sent during a session as the email's attachment
 func (b *Bot) handleAttachment(msg *tgbotapi.Message) {
 	chatID := msg.Chat.ID
 	session, ok := b.getSession(chatID)
@@ -423,99 +1113,229 @@ func (b *Bot) handleAttachment(msg *tgbotapi.Message) {
 		b.API.Send(tgbotapi.NewMessage(chatID, "No active session."))
 		return
 	}
-	doc := msg.Document
-	if doc == nil {
-		b.API.Send(tgbotapi.NewMessage(chatID, "Please send a document."))
-		return
-	}
-	fileID := doc.FileID
-	file, err := b.API.GetFile(tgbotapi.FileConfig{FileID: fileID})
-	if err != nil {
-		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to get file info: "+err.Error()))
+	media, ok := messageMedia(msg)
+	if !ok {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Please send a file, photo, voice note, audio, video or sticker."))
 		return
 	}
-	localPath := filepath.Join(attachmentsDir, doc.FileName)
-	url := file.Link(b.API.Token)
-	resp, err := http.Get(url)
+	hash, err := b.saveMedia(chatID, media)
 	if err != nil {
-		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to download file: "+err.Error()))
+		b.API.Send(tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()))
 		return
 	}
-	defer resp.Body.Close()
-	out, err := os.Create(localPath)
-	if err != nil {
-		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to create local file: "+err.Error()))
+	b.retainAttachment(hash, sessionOwner(chatID))
+
+	if looksLikeHTML(session.Body) && isInlineImage(media) {
+		session.Pending = &inlineImage{Hash: hash, Name: media.Name, Type: media.MimeType}
+		session.Step = stepPlaceImage
+		m := tgbotapi.NewMessage(chatID, "🖼 Your body is HTML. Embed this image `inline` in the body or `attach` it as a file?")
+		m.ReplyMarkup = imageKeyboard
+		b.sendKeyboard(session, m)
 		return
 	}
-	defer out.Close()
-	io.Copy(out, resp.Body)
 
-	session.FilePath = localPath
-	session.FileName = doc.FileName
-	session.Step = 6
+	session.FileHash = hash
+	session.FileName = media.Name
+	session.FileType = media.MimeType
+	session.Step = stepPreview
 	b.sendPreview(chatID, session)
 }
 
-// StartScheduledWorker periodically sends scheduled emails
-func (b *Bot) StartScheduledWorker() {
+// StartScheduledWorker periodically sends scheduled emails until ctx is done
+func (b *Bot) StartScheduledWorker(ctx context.Context) {
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-		b.dbMu.Lock()
-		rows, err := b.db.Query("SELECT id, chat_id, recipients, subject, body, attachments_json, send_at FROM scheduled_emails WHERE status = 'pending'")
-		if err != nil {
-			b.dbMu.Unlock()
-			log.Println("ScheduledWorker query error:", err)
-			continue
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.scheduleWake:
 		}
+		b.sendDue(ctx)
+	}
+}
 
-		var idsToMark []int
-		for rows.Next() {
-			var id int
-			var chatID int64
-			var recipients, subject, body, attachmentsJSON, sendAt string
-			_ = rows.Scan(&id, &chatID, &recipients, &subject, &body, &attachmentsJSON, &sendAt)
+// wakeScheduler makes the worker look for due emails now instead of at its next tick
+func (b *Bot) wakeScheduler() {
+	select {
+	case b.scheduleWake <- struct{}{}:
+	default:
+	}
+}
 
-			sendTime, err := time.Parse("2006-01-02 15:04", sendAt)
-			if err != nil {
-				sendTime, _ = time.Parse(time.RFC3339, sendAt)
+// sendDue sends the scheduled emails that are due. Once shutdown begins no
+// new job is started; one that is interrupted while sending stays pending and
+// is retried after the restart.
+func (b *Bot) sendDue(ctx context.Context) {
+	due, err := b.dueScheduled()
+	if err != nil {
+		log.Println("ScheduledWorker query error:", err)
+		return
+	}
+
+	// sending happens without holding dbMu, sendMail records history itself.
+	// Each job is claimed before it is sent, so a cancelled one is skipped, and
+	// marked as soon as it is done, so a crash or shutdown never sends a
+	// finished one again.
+	for _, job := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		id, session := job.id, job.session
+		if !b.claimScheduled(id) {
+			continue
+		}
+		acct, err := b.accountByID(session.AccountID)
+		if err != nil {
+			log.Printf("Scheduled email %d: %v", id, err)
+			b.finishScheduled(id, "failed", err.Error())
+			continue
+		}
+		if err := b.checkSize(acct, session); err != nil {
+			log.Printf("Scheduled email %d: %v, not sending", id, err)
+			b.finishScheduled(id, "failed", err.Error())
+			continue
+		}
+		if session.Encrypt {
+			if missing := b.missingPGPKeys(session.ChatID, allRecipients(session)); len(missing) > 0 {
+				log.Printf("Scheduled email %d: encryption requested but no PGP key for %s, not sending", id, strings.Join(missing, ", "))
+				b.finishScheduled(id, "failed", "no PGP key for "+strings.Join(missing, ", "))
+				continue
 			}
-			if time.Now().After(sendTime) {
-				// parse attachments
-				var attachments []map[string]string
-				_ = json.Unmarshal([]byte(attachmentsJSON), &attachments)
-
-				for _, to := range strings.Split(recipients, ",") {
-					to = strings.TrimSpace(to)
-					if err := b.sendMailWithAttachments(to, subject, body, attachments); err != nil {
-						log.Println("Scheduled sendMail error:", err)
-					}
+		}
+		session, link, err := b.withAttachmentLink(session)
+		if err != nil {
+			log.Printf("Scheduled email %d: %v", id, err)
+			b.finishScheduled(id, "failed", err.Error())
+			continue
+		}
+		toList := splitRecipients(session.To)
+		aborted := false
+		var failures []string
+		delivered := 0
+		for i, to := range toList {
+			if err := b.sendMail(b.sendCtx, acct, to, copyTo(i, session), session); err != nil {
+				if b.sendCtx.Err() != nil {
+					aborted = true
+					break
 				}
-				idsToMark = append(idsToMark, id)
+				log.Println("Scheduled sendMail error:", err)
+				failures = append(failures, to+": "+err.Error())
+				continue
 			}
+			delivered++
 		}
-		rows.Close()
-
-		// mark sent
-		for _, id := range idsToMark {
-			_, _ = b.db.Exec("UPDATE scheduled_emails SET status='sent' WHERE id=?", id)
+		if delivered == 0 && link != "" {
+			// nobody got the link; a retry makes a new one
+			b.deleteDownloadLink(link)
+		}
+		if aborted {
+			log.Printf("Scheduled email %d: interrupted by shutdown, left pending", id)
+			b.unclaimScheduled(id)
+			continue
+		}
+		status := "sent"
+		if len(failures) > 0 && len(failures) == len(toList) {
+			status = "failed"
 		}
-		b.dbMu.Unlock()
+		b.finishScheduled(id, status, strings.Join(failures, "; "))
 	}
 }
 
-// helper for sending attachments from DB
-func (b *Bot) sendMailWithAttachments(to, subject, body string, attachments []map[string]string) error {
-	if len(attachments) > 0 {
-		for _, att := range attachments {
-			err := b.sendMail(to, subject, body, att["path"], att["name"])
-			if err != nil {
-				return err
+// claimScheduled moves a pending email to sending; it reports false when the
+// email was cancelled or claimed in the meantime
+func (b *Bot) claimScheduled(id int) bool {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	res, err := b.db.Exec("UPDATE scheduled_emails SET status = 'sending' WHERE id = ? AND status = 'pending'", id)
+	if err != nil {
+		log.Printf("Scheduled email %d: claim: %v", id, err)
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// unclaimScheduled puts an email whose send was interrupted back to pending
+func (b *Bot) unclaimScheduled(id int) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	if _, err := b.db.Exec("UPDATE scheduled_emails SET status = 'pending' WHERE id = ? AND status = 'sending'", id); err != nil {
+		log.Printf("Scheduled email %d: unclaim: %v", id, err)
+	}
+}
+
+// finishScheduled records the outcome of a scheduled email, with the errors
+// that occurred if any, and releases its attachments
+func (b *Bot) finishScheduled(id int, status, detail string) {
+	b.releaseAttachments(scheduledOwner(int64(id)))
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	if _, err := b.db.Exec("UPDATE scheduled_emails SET status = ?, error = ? WHERE id = ?", status, detail, id); err != nil {
+		log.Printf("Scheduled email %d: mark %s: %v", id, status, err)
+	}
+}
+
+type scheduledJob struct {
+	id      int
+	session *EmailSession
+}
+
+// dueScheduled loads the pending scheduled emails whose send time has passed
+func (b *Bot) dueScheduled() ([]scheduledJob, error) {
+	rows, err := b.db.Query("SELECT id, chat_id, account_id, encrypt, as_link, in_reply_to, references_list, recipients, cc, subject, body, attachments_json, send_at FROM scheduled_emails WHERE status = 'pending'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []scheduledJob
+	for rows.Next() {
+		var id int
+		var chatID, accountID int64
+		var encrypt, asLink bool
+		var inReplyTo, references, recipients, cc, subject, body, attachmentsJSON, sendAt string
+		_ = rows.Scan(&id, &chatID, &accountID, &encrypt, &asLink, &inReplyTo, &references, &recipients, &cc, &subject, &body, &attachmentsJSON, &sendAt)
+
+		sendTime, err := time.Parse("2006-01-02 15:04", sendAt)
+		if err != nil {
+			if sendTime, err = time.Parse(time.RFC3339, sendAt); err != nil {
+				// never send at a time nobody chose
+				log.Printf("Scheduled email %d: skipping, unreadable send time %q", id, sendAt)
+				continue
 			}
 		}
-		return nil
+		if !time.Now().After(sendTime) {
+			continue
+		}
+
+		// parse attachments
+		var attachments []map[string]string
+		_ = json.Unmarshal([]byte(attachmentsJSON), &attachments)
+
+		session := &EmailSession{
+			To:        recipients,
+			Cc:        cc,
+			Subject:   subject,
+			Body:      body,
+			ChatID:    chatID,
+			AccountID: accountID,
+			Encrypt:   encrypt,
+			AsLink:    asLink,
+
+			InReplyTo:  inReplyTo,
+			References: strings.Fields(references),
+		}
+		for _, a := range attachments {
+			if a["cid"] != "" {
+				session.Inline = append(session.Inline, inlineImage{ContentID: a["cid"], Hash: a["sha256"], Name: a["name"], Type: a["type"]})
+			} else {
+				session.FileHash, session.FileName, session.FileType = a["sha256"], a["name"], a["type"]
+			}
+		}
+		due = append(due, scheduledJob{id: id, session: session})
 	}
-	return b.sendMail(to, subject, body, "", "")
+	return due, rows.Err()
 }
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// defaultUpdateWorkers bounds how many chats are handled at the same time
+// when UPDATE_WORKERS is not set
+const defaultUpdateWorkers = 8
+
+// dispatcher runs update handlers for different chats concurrently while
+// keeping each chat's updates in order, one at a time. A chat's compose
+// session is therefore only ever touched by one goroutine at a time, which is
+// what makes mutating *EmailSession without holding sessionsMu safe.
+type dispatcher struct {
+	handle func(tgbotapi.Update)
+	slots  chan struct{} // one token per running handler
+
+	mu     sync.Mutex
+	queues map[int64][]tgbotapi.Update // pending updates of chats being worked on
+	wg     sync.WaitGroup
+}
+
+func newDispatcher(workers int, handle func(tgbotapi.Update)) *dispatcher {
+	if workers <= 0 {
+		workers = defaultUpdateWorkers
+	}
+	return &dispatcher{
+		handle: handle,
+		slots:  make(chan struct{}, workers),
+		queues: make(map[int64][]tgbotapi.Update),
+	}
+}
+
+// updateChatID is the chat an update belongs to; updates without one share chat 0
+func updateChatID(u tgbotapi.Update) int64 {
+	switch {
+	case u.Message != nil:
+		return u.Message.Chat.ID
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		return u.CallbackQuery.Message.Chat.ID
+	case u.CallbackQuery != nil:
+		return u.CallbackQuery.From.ID
+	}
+	return 0
+}
+
+// submit queues an update behind the chat's earlier ones
+func (d *dispatcher) submit(u tgbotapi.Update) {
+	chatID := updateChatID(u)
+	d.mu.Lock()
+	queue, busy := d.queues[chatID]
+	d.queues[chatID] = append(queue, u)
+	d.mu.Unlock()
+	if !busy {
+		d.wg.Add(1)
+		go d.run(chatID)
+	}
+}
+
+// run handles the chat's queue until it is empty
+func (d *dispatcher) run(chatID int64) {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		queue := d.queues[chatID]
+		if len(queue) == 0 {
+			delete(d.queues, chatID)
+			d.mu.Unlock()
+			return
+		}
+		u := queue[0]
+		d.queues[chatID] = queue[1:]
+		d.mu.Unlock()
+
+		d.slots <- struct{}{}
+		d.handle(u)
+		<-d.slots
+	}
+}
+
+// wait blocks until every submitted update has been handled
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestDispatcherOrderAndLimit(t *testing.T) {
+	const (
+		workers = 3
+		chats   = 7
+		perChat = 25
+	)
+	var (
+		running, peak atomic.Int32
+		mu            sync.Mutex
+		seen          = map[int64][]int{}
+		busy          = map[int64]bool{}
+	)
+	d := newDispatcher(workers, func(u tgbotapi.Update) {
+		n := running.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		chatID := updateChatID(u)
+		mu.Lock()
+		if busy[chatID] {
+			t.Errorf("chat %d handled by two goroutines at once", chatID)
+		}
+		busy[chatID] = true
+		seen[chatID] = append(seen[chatID], u.UpdateID)
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		busy[chatID] = false
+		mu.Unlock()
+		running.Add(-1)
+	})
+
+	// interleave the chats' updates the way they arrive from Telegram, with
+	// callback queries mixed in among the messages
+	for i := range perChat {
+		for chat := int64(1); chat <= chats; chat++ {
+			u := tgbotapi.Update{UpdateID: i}
+			if i%3 == 2 {
+				u.CallbackQuery = &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: chat}}
+			} else {
+				u.Message = &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chat}}
+			}
+			d.submit(u)
+		}
+	}
+	d.wait()
+
+	if p := peak.Load(); p > workers {
+		t.Errorf("%d handlers ran at once, limit is %d", p, workers)
+	} else if p < 2 {
+		t.Errorf("at most %d handler ran at once, want chats handled concurrently", p)
+	}
+	for chat := int64(1); chat <= chats; chat++ {
+		got := seen[chat]
+		if len(got) != perChat {
+			t.Fatalf("chat %d: %d updates handled, want %d", chat, len(got), perChat)
+		}
+		for i, id := range got {
+			if id != i {
+				t.Fatalf("chat %d: update %d handled in position %d: %v", chat, id, i, got)
+			}
+		}
+	}
+	if len(d.queues) != 0 {
+		t.Errorf("%d chat queues left after wait", len(d.queues))
+	}
+}
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// A draft is a compose session saved at the preview. It keeps references to
+// its attachments until it is opened again or deleted.
+
+// saveDraft stores the session as a draft and ends it
+func (b *Bot) saveDraft(chatID int64, session *EmailSession) {
+	j, err := json.Marshal(session)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to save the draft: "+err.Error()))
+		return
+	}
+	b.dbMu.Lock()
+	res, err := b.db.Exec("INSERT INTO drafts (chat_id, recipients, subject, session_json, created_at) VALUES (?, ?, ?, ?, ?)",
+		chatID, session.To, session.Subject, string(j), time.Now().UTC().Format(time.RFC3339))
+	b.dbMu.Unlock()
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to save the draft: "+err.Error()))
+		return
+	}
+	id, _ := res.LastInsertId()
+	for _, h := range sessionHashes(session) {
+		b.retainAttachment(h, draftOwner(id))
+	}
+	b.deleteSession(chatID)
+	b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("💾 Draft #%d saved. Use /drafts to continue it later.", id)))
+}
+
+// cmdDrafts lists the chat's drafts with buttons to open or delete them
+func (b *Bot) cmdDrafts(msg *tgbotapi.Message) {
+	rows, err := b.db.Query("SELECT id, recipients, subject, created_at FROM drafts WHERE chat_id = ? ORDER BY id DESC LIMIT 20", msg.Chat.ID)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "Failed to query drafts: "+err.Error()))
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for rows.Next() {
+		var id int64
+		var recipients, subject, createdAt string
+		_ = rows.Scan(&id, &recipients, &subject, &createdAt)
+		lines = append(lines, fmt.Sprintf("#%d — to:%s — %s — saved %s", id, recipients, subject, createdAt))
+		keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("✏️ Open #%d", id), fmt.Sprintf("d:open:%d", id)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🗑 Delete #%d", id), fmt.Sprintf("d:del:%d", id)),
+		))
+	}
+	if len(lines) == 0 {
+		b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "No drafts saved. Use 💾 Save draft at the preview."))
+		return
+	}
+	m := tgbotapi.NewMessage(msg.Chat.ID, "📝 Drafts\n"+strings.Join(lines, "\n"))
+	m.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	b.API.Send(m)
+}
+
+// handleDraftCallback opens or deletes a draft from the /drafts list
+func (b *Bot) handleDraftCallback(cq *tgbotapi.CallbackQuery) {
+	chatID := cq.Message.Chat.ID
+	id, ok := callbackID(cq.Data)
+	if !ok {
+		b.API.Request(tgbotapi.NewCallback(cq.ID, ""))
+		return
+	}
+	switch {
+	case strings.HasPrefix(cq.Data, "d:open:"):
+		if b.hasSession(chatID) {
+			b.API.Request(tgbotapi.NewCallback(cq.ID, "Finish or /cancel the current email first."))
+			return
+		}
+		session, err := b.loadDraft(chatID, id)
+		if err != nil {
+			b.API.Request(tgbotapi.NewCallback(cq.ID, "This draft no longer exists."))
+			b.clearButtons(cq.Message)
+			return
+		}
+		b.API.Request(tgbotapi.NewCallback(cq.ID, ""))
+		b.clearButtons(cq.Message)
+		b.setSession(chatID, session)
+		b.deleteDraft(chatID, id)
+		b.sendPreview(chatID, session)
+	case strings.HasPrefix(cq.Data, "d:del:"):
+		b.deleteDraft(chatID, id)
+		b.API.Request(tgbotapi.NewCallback(cq.ID, fmt.Sprintf("Draft #%d deleted.", id)))
+		b.clearButtons(cq.Message)
+	default:
+		b.API.Request(tgbotapi.NewCallback(cq.ID, ""))
+	}
+}
+
+// loadDraft restores a draft of the chat as a compose session
+func (b *Bot) loadDraft(chatID, id int64) (*EmailSession, error) {
+	var sessionJSON string
+	if err := b.db.QueryRow("SELECT session_json FROM drafts WHERE id = ? AND chat_id = ?", id, chatID).Scan(&sessionJSON); err != nil {
+		return nil, err
+	}
+	var s EmailSession
+	if err := json.Unmarshal([]byte(sessionJSON), &s); err != nil {
+		return nil, fmt.Errorf("draft %d: %w", id, err)
+	}
+	s.ChatID = chatID
+	s.CreatedAt = time.Now().UTC()
+	return &s, nil
+}
+
+// deleteDraft removes a draft of the chat and releases its attachments
+func (b *Bot) deleteDraft(chatID, id int64) {
+	b.dbMu.Lock()
+	res, err := b.db.Exec("DELETE FROM drafts WHERE id = ? AND chat_id = ?", id, chatID)
+	b.dbMu.Unlock()
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		b.releaseAttachments(draftOwner(id))
+	}
+}
@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The preview's Edit buttons send the wizard back to a single step with
+// Editing set; the step then returns to the preview instead of moving on.
+
+// startEdit jumps back to the step that asks for field
+func (b *Bot) startEdit(chatID int64, session *EmailSession, field string) {
+	session.Editing = true
+	switch field {
+	case "to":
+		session.Step = stepTo
+		b.API.Send(tgbotapi.NewMessage(chatID, "📬 New recipient(s)? Currently: "+session.To))
+	case "subject":
+		session.Step = stepSubject
+		b.API.Send(tgbotapi.NewMessage(chatID, "✏️ New subject? Currently: "+session.Subject))
+	case "body":
+		session.Step = stepBody
+		session.BodyParts = nil
+		b.API.Send(tgbotapi.NewMessage(chatID, "📝 Send the new body in one or more messages, then /done. The current body follows so you can copy it."))
+		if current := trimQuote(session); current != "" {
+			b.API.Send(tgbotapi.NewMessage(chatID, current))
+		}
+	case "files":
+		session.Step = stepUpload
+		m := tgbotapi.NewMessage(chatID, "📂 Send a file to replace the attachment, or remove the current ones.")
+		m.ReplyMarkup = editFilesKeyboard
+		b.sendKeyboard(session, m)
+	default:
+		session.Editing = false
+		b.sendPreview(chatID, session)
+	}
+}
+
+// trimQuote is the body as the user wrote it, without the quoted original of a reply
+func trimQuote(session *EmailSession) string {
+	return strings.TrimSuffix(session.Body, session.Quote)
+}
+
+// removeAttachments drops the file and inline images of the session
+func (b *Bot) removeAttachments(chatID int64, session *EmailSession) {
+	session.FileHash, session.FileName, session.FileType = "", "", ""
+	session.Inline, session.Pending, session.AsLink = nil, nil, false
+	b.releaseAttachments(sessionOwner(chatID))
+	b.sendPreview(chatID, session)
+}
+
+// setSchedule sets the send time shown in the preview; it is used when the
+// email is confirmed there
+func (b *Bot) setSchedule(chatID int64, session *EmailSession, when string) {
+	if problem := scheduleTimeProblem(when); problem != "" {
+		session.Step = stepSendTime
+		b.API.Send(tgbotapi.NewMessage(chatID, problem))
+		return
+	}
+	session.Schedule = when
+	b.sendPreview(chatID, session)
+}
+
+// scheduleTimeProblem explains why when (YYYY-MM-DD HH:MM, UTC) can't be used
+// as a send time, or is empty when it can
+func scheduleTimeProblem(when string) string {
+	t, err := time.Parse("2006-01-02 15:04", when)
+	if err != nil {
+		return "Please send the time as YYYY-MM-DD HH:MM (UTC)."
+	}
+	if !t.After(time.Now()) {
+		return fmt.Sprintf("%s UTC has already passed, please send a later time.", when)
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// isForwarded reports whether msg was forwarded from another chat
+func isForwarded(msg *tgbotapi.Message) bool {
+	return msg.ForwardDate != 0
+}
+
+// forwardOrigin names who originally sent a forwarded message, empty when unknown
+func forwardOrigin(msg *tgbotapi.Message) string {
+	switch {
+	case msg.ForwardFrom != nil:
+		return strings.TrimSpace(msg.ForwardFrom.FirstName + " " + msg.ForwardFrom.LastName)
+	case msg.ForwardFromChat != nil:
+		return msg.ForwardFromChat.Title
+	}
+	return msg.ForwardSenderName
+}
+
+// cmdMail emails the message it replies to: /mail <addr>
+func (b *Bot) cmdMail(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	to := strings.TrimSpace(msg.CommandArguments())
+	if msg.ReplyToMessage == nil || to == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Reply to a message with /mail <address> to email it, or forward a message to me."))
+		return
+	}
+	b.startForward(msg.ReplyToMessage, to)
+}
+
+// startForward opens a session pre-filled from a Telegram message: its text or
+// caption becomes the body and its media the attachment. Without a recipient
+// the session asks for one, then goes straight to the preview.
+func (b *Bot) startForward(src *tgbotapi.Message, to string) {
+	chatID := src.Chat.ID
+	subject := "Forwarded from Telegram"
+	if origin := forwardOrigin(src); origin != "" {
+		subject = "Fwd: message from " + origin
+	}
+	body := src.Text
+	if body == "" {
+		body = src.Caption
+	}
+	s := &EmailSession{
+		Step:      stepTo,
+		To:        to,
+		Subject:   subject,
+		Body:      body,
+		ChatID:    chatID,
+		AccountID: b.activeAccountID(chatID),
+		Prefilled: true,
+		CreatedAt: time.Now().UTC(),
+	}
+	if media, ok := messageMedia(src); ok {
+		b.API.Send(tgbotapi.NewMessage(chatID, "⬇️ Downloading "+media.Name+"..."))
+		hash, err := b.saveMedia(chatID, media)
+		if err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()))
+			return
+		}
+		s.FileHash, s.FileName, s.FileType = hash, media.Name, media.MimeType
+	}
+	b.setSession(chatID, s)
+
+	if to == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "📨 Who should this message be emailed to? (comma separated addresses are allowed)"))
+		return
+	}
+	b.sendPreview(chatID, s)
+}
@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/scan"
+)
+
+// fakeTelegram stands in for the Bot API. It answers getMe, records every
+// other call and fails the next failSends sendMessage calls with a 429.
+type fakeTelegram struct {
+	mu        sync.Mutex
+	calls     []telegramCall
+	failSends int
+	nextID    int
+}
+
+type telegramCall struct {
+	Method string
+	Params map[string]string
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:]
+	if method == "getMe" {
+		fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Test","username":"test_bot"}}`)
+		return
+	}
+	_ = r.ParseMultipartForm(1 << 20)
+	params := map[string]string{}
+	for k, v := range r.Form {
+		params[k] = v[0]
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if method == "sendMessage" && f.failSends > 0 {
+		f.failSends--
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`)
+		return
+	}
+	f.calls = append(f.calls, telegramCall{method, params})
+	f.nextID++
+	var chatID int64
+	fmt.Sscan(params["chat_id"], &chatID)
+	result, _ := json.Marshal(map[string]any{"message_id": f.nextID, "date": 0, "chat": map[string]any{"id": chatID, "type": "private"}})
+	fmt.Fprintf(w, `{"ok":true,"result":%s}`, result)
+}
+
+// sent returns the successful calls of one method
+func (f *fakeTelegram) sent(method string) []telegramCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []telegramCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// newTestBot returns a bot with a fresh database and attachment store in a
+// temporary directory, talking to a fake Telegram
+func newTestBot(t *testing.T) (*Bot, *fakeTelegram) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	tg := &fakeTelegram{}
+	srv := httptest.NewServer(tg)
+	t.Cleanup(srv.Close)
+	api, err := tgbotapi.NewBotAPIWithClient("token", srv.URL+"/bot%s/%s", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", sqliteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := initDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Bot{
+		API:            api,
+		defaultAccount: &Account{Name: "default", Username: "bot@example.com", Host: "localhost", Port: 25},
+		sessions:       make(map[int64]*EmailSession),
+		updateWorkers:  defaultUpdateWorkers,
+		scheduleWake:   make(chan struct{}, 1),
+		db:             db,
+		tokens:         make(map[int64]*mail.TokenSource),
+		smtpPool:       mail.NewPool(smtpIdleTimeout),
+		mux:            http.NewServeMux(),
+		scanner:        scan.Nop{},
+		webhookUpdates: make(chan tgbotapi.Update, 100),
+	}
+	b.sendCtx, b.abortSends = context.WithCancel(context.Background())
+	t.Cleanup(b.abortSends)
+	return b, tg
+}
+
+// smtpSink is a plain SMTP server that accepts every message and keeps it
+type smtpSink struct {
+	mu       sync.Mutex
+	conns    int
+	messages []string
+}
+
+// startSMTP points the default account at a fresh smtpSink, without AUTH
+func startSMTP(t *testing.T, b *Bot) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	sink := &smtpSink{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sink.mu.Lock()
+			sink.conns++
+			sink.mu.Unlock()
+			go sink.serve(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	b.defaultAccount.Host, b.defaultAccount.Port = addr.IP.String(), addr.Port
+	b.defaultAccount.Auth = "none"
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	write := func(line string) { fmt.Fprint(conn, line+"\r\n") }
+	write("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(strings.Fields(line + " x")[0]) {
+		case "DATA":
+			write("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+			write("250 queued")
+		case "QUIT":
+			write("221 bye")
+			return
+		default:
+			write("250 ok")
+		}
+	}
+}
+
+// stats returns the number of connections and the delivered messages
+func (s *smtpSink) stats() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, append([]string(nil), s.messages...)
+}
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// historyLimit is how many sent messages /history lists
+const historyLimit = 20
+
+// sentEmail is a delivered message as recorded in sent_emails
+type sentEmail struct {
+	ID         int64
+	AccountID  int64
+	MessageID  string
+	References []string
+	To         string
+	Subject    string
+	SentAt     string
+}
+
+// recordSent stores a delivered message so follow-ups can thread onto it
+func (b *Bot) recordSent(session *EmailSession, to, messageID string) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	_, err := b.db.Exec(`INSERT INTO sent_emails
+	(chat_id, account_id, message_id, references_list, recipient, subject, sent_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.ChatID, session.AccountID, messageID, strings.Join(session.References, " "), to, session.Subject,
+		time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Println("record sent email:", err)
+	}
+}
+
+func (b *Bot) sentEmailByID(chatID, id int64) (*sentEmail, error) {
+	var e sentEmail
+	var refs string
+	err := b.db.QueryRow(`SELECT id, account_id, message_id, references_list, recipient, subject, sent_at
+	FROM sent_emails WHERE chat_id = ? AND id = ?`, chatID, id).
+		Scan(&e.ID, &e.AccountID, &e.MessageID, &refs, &e.To, &e.Subject, &e.SentAt)
+	if err != nil {
+		return nil, err
+	}
+	e.References = strings.Fields(refs)
+	return &e, nil
+}
+
+// cmdHistory lists the chat's most recently sent messages
+func (b *Bot) cmdHistory(msg *tgbotapi.Message) {
+	rows, err := b.db.Query(`SELECT id, recipient, subject, sent_at FROM sent_emails
+	WHERE chat_id = ? ORDER BY id DESC LIMIT ?`, msg.Chat.ID, historyLimit)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "Failed to query history: "+err.Error()))
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var e sentEmail
+		_ = rows.Scan(&e.ID, &e.To, &e.Subject, &e.SentAt)
+		lines = append(lines, fmt.Sprintf("ID:%d — to:%s — %s — %s", e.ID, e.To, e.Subject, e.SentAt))
+	}
+	if len(lines) == 0 {
+		b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "No sent emails yet."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(msg.Chat.ID, "📜 Sent emails:\n"+strings.Join(lines, "\n")+"\n\nUse /followup <id> to write a follow-up in the same thread."))
+}
+
+// cmdFollowup starts a compose session threaded onto a sent message: /followup <history-id>
+func (b *Bot) cmdFollowup(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	id, err := strconv.ParseInt(strings.TrimSpace(msg.CommandArguments()), 10, 64)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /followup <id> (see /history for ids)"))
+		return
+	}
+	e, err := b.sentEmailByID(chatID, id)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("No sent email with ID %d.", id)))
+		return
+	}
+
+	s := &EmailSession{
+		Step:       stepBody,
+		To:         e.To,
+		Subject:    replySubject(e.Subject),
+		ChatID:     chatID,
+		AccountID:  e.AccountID,
+		InReplyTo:  e.MessageID,
+		References: append(e.References, e.MessageID),
+		CreatedAt:  time.Now().UTC(),
+	}
+	b.setSession(chatID, s)
+	b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🧵 Follow-up to %s\nSubject: %s\n\n📝 Body text? Send one or more messages, then /done.", s.To, s.Subject)))
+}
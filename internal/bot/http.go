@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StartHTTPServer serves the bot's HTTP endpoints on HTTP_ADDR until ctx is
+// done; it does nothing when no address is configured. Requests still running
+// then get until in-flight deliveries are abandoned to finish.
+func (b *Bot) StartHTTPServer(ctx context.Context) {
+	if b.httpAddr == "" {
+		return
+	}
+	srv := &http.Server{
+		Addr:              b.httpAddr,
+		Handler:           b.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		// cancels request contexts at shutdown, so blocked webhook posts give up
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		if err := srv.Shutdown(b.sendCtx); err != nil {
+			log.Println("http server shutdown:", err)
+		}
+	}()
+
+	log.Printf("http server listening on %s", b.httpAddr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Println("http server:", err)
+		return
+	}
+	<-stopped
+}
+
+// publicLink returns the absolute URL of a path on the bot's HTTP server
+func (b *Bot) publicLink(path string) string {
+	return strings.TrimRight(b.publicURL, "/") + path
+}
@@ -0,0 +1,361 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+const (
+	// imapRefreshInterval is how often the set of watched mailboxes is reloaded
+	imapRefreshInterval = time.Minute
+	// imapRetryDelay is the pause before reconnecting after an IMAP error
+	imapRetryDelay = time.Minute
+	// imapPollInterval is used for servers without IDLE support
+	imapPollInterval = time.Minute
+	// inboundExcerptLen bounds the body text shown in chat
+	inboundExcerptLen = 1500
+	// maxTelegramUpload is the largest document a bot may send
+	maxTelegramUpload = 50 << 20
+)
+
+// imapKey identifies a mailbox watcher; it changes when the mailbox settings do
+func (a *Account) imapKey() string {
+	return fmt.Sprintf("%d|%d|%s|%s:%d|%s|%s", a.ID, a.ChatID, a.Username, a.IMAPHost, a.IMAPPort, a.IMAPTLS, a.imapFolder())
+}
+
+func (a *Account) imapFolder() string {
+	if a.IMAPFolder == "" {
+		return "INBOX"
+	}
+	return a.IMAPFolder
+}
+
+func (a *Account) imapDialConfig() mail.DialConfig {
+	port := a.IMAPPort
+	if port == 0 {
+		port = 993
+	}
+	return mail.DialConfig{
+		Host:       a.IMAPHost,
+		Port:       port,
+		Mode:       a.IMAPTLS,
+		ServerName: a.ServerName,
+		CAFile:     a.CAFile,
+		CAPEM:      []byte(a.CAPEM),
+	}
+}
+
+// StartInboxWatcher keeps one IMAP watcher running for every account with a
+// mailbox configured, starting and stopping watchers as accounts change,
+// until ctx is done
+func (b *Bot) StartInboxWatcher(ctx context.Context) {
+	running := map[string]chan struct{}{}
+	var wg sync.WaitGroup
+	for {
+		wanted := map[string]*Account{}
+		for _, a := range b.watchedAccounts() {
+			wanted[a.imapKey()] = a
+		}
+		for key, stop := range running {
+			if _, ok := wanted[key]; !ok {
+				close(stop)
+				delete(running, key)
+			}
+		}
+		for key, a := range wanted {
+			if _, ok := running[key]; !ok {
+				stop := make(chan struct{})
+				running[key] = stop
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b.watchInbox(a, stop)
+				}()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			// each watcher stops IDLE and logs out
+			for _, stop := range running {
+				close(stop)
+			}
+			wg.Wait()
+			return
+		case <-time.After(imapRefreshInterval):
+		}
+	}
+}
+
+// watchedAccounts lists the accounts whose mailbox should be forwarded to their chat
+func (b *Bot) watchedAccounts() []*Account {
+	var out []*Account
+	if b.defaultAccount.IMAPHost != "" && b.defaultAccount.ChatID != 0 {
+		out = append(out, b.defaultAccount)
+	}
+	if b.secrets == nil {
+		return out
+	}
+	rows, err := b.db.Query("SELECT " + accountColumns + " FROM smtp_accounts")
+	if err != nil {
+		log.Println("inbox: query accounts:", err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := b.scanAccount(rows)
+		if err != nil {
+			log.Println("inbox:", err)
+			continue
+		}
+		if a.IMAPHost != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// watchInbox forwards new mail from the account's mailbox until stop is closed,
+// reconnecting after errors
+func (b *Bot) watchInbox(acct *Account, stop <-chan struct{}) {
+	log.Printf("inbox: watching %s/%s for chat %d", acct.Name, acct.imapFolder(), acct.ChatID)
+	for {
+		err := b.runInbox(acct, stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		log.Printf("inbox %s: %v, retrying in %s", acct.Name, err, imapRetryDelay)
+		select {
+		case <-stop:
+			return
+		case <-time.After(imapRetryDelay):
+		}
+	}
+}
+
+// runInbox holds one IMAP session: forward what is new, then IDLE (or poll)
+// until the server reports a change
+func (b *Bot) runInbox(acct *Account, stop <-chan struct{}) error {
+	c, err := mail.DialIMAP(acct.imapDialConfig(), b.imapLogin(acct))
+	if err != nil {
+		return err
+	}
+	// the client blocks on a full Updates channel, so drain it into a wake-up signal
+	updates := make(chan client.Update, 16)
+	changed := make(chan struct{}, 1)
+	c.Updates = updates
+	go func() {
+		for range updates {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	defer func() {
+		c.Logout()
+		close(updates)
+	}()
+
+	folder := acct.imapFolder()
+	status, err := c.Select(folder, true)
+	if err != nil {
+		return fmt.Errorf("select %s: %w", folder, err)
+	}
+
+	for {
+		if err := b.forwardNew(acct, c, folder, status.UidValidity, status.UidNext); err != nil {
+			return err
+		}
+
+		// the command timeout would cut IDLE short, it only guards regular commands
+		timeout := c.Timeout
+		c.Timeout = 0
+		idleStop := make(chan struct{})
+		idleDone := make(chan error, 1)
+		go func() {
+			idleDone <- c.Idle(idleStop, &client.IdleOptions{PollInterval: imapPollInterval})
+		}()
+		select {
+		case <-changed:
+			close(idleStop)
+			err = <-idleDone
+		case <-stop:
+			close(idleStop)
+			<-idleDone
+			return nil
+		case err = <-idleDone:
+			if err == nil {
+				err = errors.New("idle ended unexpectedly")
+			}
+		}
+		c.Timeout = timeout
+		if err != nil {
+			return err
+		}
+		if mbox := c.Mailbox(); mbox != nil {
+			status = mbox
+		}
+	}
+}
+
+// imapLogin authenticates with the account's SMTP credentials
+func (b *Bot) imapLogin(acct *Account) func(*client.Client) error {
+	return func(c *client.Client) error {
+		if acct.Auth == "xoauth2" {
+			return c.Authenticate(mail.XOAuth2SASL(acct.Username, b.tokenSource(acct)))
+		}
+		return c.Login(acct.Username, acct.Password)
+	}
+}
+
+// forwardNew posts every message above the last forwarded UID to the chat.
+// The UID only moves past a message once it is in the chat; when posting
+// fails the error is returned and the message is fetched again on the retry.
+func (b *Bot) forwardNew(acct *Account, c *client.Client, folder string, validity, uidNext uint32) error {
+	last, ok := b.imapLastUID(acct.ID, folder, validity)
+	if !ok {
+		// first time watching (or the mailbox was rebuilt): start from now
+		// rather than flooding the chat with the whole history
+		if uidNext > 0 {
+			last = uidNext - 1
+		}
+		return b.setIMAPLastUID(acct.ID, folder, validity, last)
+	}
+
+	msgs, failed, err := mail.FetchSince(c, last)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	if len(failed) > 0 {
+		log.Printf("inbox %s: %s: could not parse messages %v", acct.Name, folder, failed)
+	}
+	// go through both in UID order so the last UID never skips a message
+	byUID := make(map[uint32]*mail.InboundMessage, len(msgs))
+	uids := slices.Clone(failed)
+	for _, m := range msgs {
+		byUID[m.UID] = m
+		uids = append(uids, m.UID)
+	}
+	slices.Sort(uids)
+	for _, uid := range uids {
+		if m, ok := byUID[uid]; ok {
+			err = b.postInbound(acct, folder, validity, m)
+		} else {
+			err = b.postUnparsed(acct, folder, uid)
+		}
+		if err != nil {
+			return fmt.Errorf("forward message %d: %w", uid, err)
+		}
+		if uid > last {
+			last = uid
+			if err := b.setIMAPLastUID(acct.ID, folder, validity, last); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postUnparsed tells the chat about a message that could not be read, so
+// moving past its UID is never silent
+func (b *Bot) postUnparsed(acct *Account, folder string, uid uint32) error {
+	_, err := b.API.Send(tgbotapi.NewMessage(acct.ChatID,
+		fmt.Sprintf("⚠️ Could not parse message %d from %s (%s). Open it in your mail client.", uid, folder, acct.Name)))
+	return err
+}
+
+func (b *Bot) imapLastUID(accountID int64, folder string, validity uint32) (uint32, bool) {
+	var storedValidity, last uint32
+	err := b.db.QueryRow("SELECT uid_validity, last_uid FROM imap_state WHERE account_id = ? AND folder = ?", accountID, folder).Scan(&storedValidity, &last)
+	if err != nil || storedValidity != validity {
+		return 0, false
+	}
+	return last, true
+}
+
+func (b *Bot) setIMAPLastUID(accountID int64, folder string, validity, last uint32) error {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	_, err := b.db.Exec(`INSERT INTO imap_state (account_id, folder, uid_validity, last_uid) VALUES (?, ?, ?, ?)
+	ON CONFLICT(account_id, folder) DO UPDATE SET uid_validity = excluded.uid_validity, last_uid = excluded.last_uid`,
+		accountID, folder, validity, last)
+	return err
+}
+
+// postInbound records the message and shows it in the account's chat. A
+// message already shown is skipped so nothing is forwarded twice; one whose
+// post failed has no tg_message_id yet and is posted again.
+func (b *Bot) postInbound(acct *Account, folder string, validity uint32, m *mail.InboundMessage) error {
+	var inboundID int64
+	var posted sql.NullInt64
+	b.dbMu.Lock()
+	_, err := b.db.Exec(`INSERT OR IGNORE INTO inbound_emails
+	(chat_id, account_id, folder, uid_validity, uid, message_id, references_list, from_addr, reply_to, subject, body, received_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		acct.ChatID, acct.ID, folder, validity, m.UID, m.MessageID, strings.Join(m.References, " "),
+		m.From, m.ReplyTo, m.Subject, m.Text, time.Now().UTC().Format(time.RFC3339))
+	if err == nil {
+		err = b.db.QueryRow("SELECT id, tg_message_id FROM inbound_emails WHERE account_id = ? AND folder = ? AND uid_validity = ? AND uid = ?",
+			acct.ID, folder, validity, m.UID).Scan(&inboundID, &posted)
+	}
+	b.dbMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if posted.Valid {
+		return nil
+	}
+
+	from := m.From
+	if m.FromName != "" {
+		from = m.FromName + " <" + m.From + ">"
+	}
+	excerpt := m.Text
+	if r := []rune(excerpt); len(r) > inboundExcerptLen {
+		excerpt = string(r[:inboundExcerptLen]) + "…"
+	}
+	text := fmt.Sprintf("📥 New email (%s)\nFrom: %s\nSubject: %s", acct.Name, from, m.Subject)
+	if len(m.Attachments) > 0 {
+		text += fmt.Sprintf("\nAttachments: %d", len(m.Attachments))
+	}
+	if excerpt != "" {
+		text += "\n\n" + excerpt
+	}
+	sent, err := b.API.Send(tgbotapi.NewMessage(acct.ChatID, text))
+	if err != nil {
+		return err
+	}
+	b.dbMu.Lock()
+	_, err = b.db.Exec("UPDATE inbound_emails SET tg_message_id = ? WHERE id = ?", sent.MessageID, inboundID)
+	b.dbMu.Unlock()
+	if err != nil {
+		// the message is in the chat; a retry would post it twice
+		log.Printf("inbox %s: record message %d as posted: %v", acct.Name, m.UID, err)
+	}
+
+	for _, a := range m.Attachments {
+		if len(a.Data) > maxTelegramUpload {
+			b.API.Send(tgbotapi.NewMessage(acct.ChatID, "📎 "+a.Name+" is too large to forward to Telegram."))
+			continue
+		}
+		doc := tgbotapi.NewDocument(acct.ChatID, tgbotapi.FileBytes{Name: a.Name, Bytes: a.Data})
+		doc.ReplyToMessageID = sent.MessageID
+		if _, err := b.API.Send(doc); err != nil {
+			log.Printf("inbox %s: send attachment %s: %v", acct.Name, a.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"bytes"
+	"database/sql"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+// startIMAP serves go-imap's in-memory backend on a local port. Its INBOX
+// holds one message with UID 6; the user is "username" / "password".
+func startIMAP(t *testing.T, extra ...string) (host string, port int) {
+	t.Helper()
+	be := memory.New()
+	user, err := be.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range extra {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestForwardNewRetriesAfterFailedPost(t *testing.T) {
+	host, port := startIMAP(t, "From: Alice <alice@example.com>\r\n"+
+		"Subject: Quarterly report\r\n"+
+		"Message-ID: <report@example.com>\r\n"+
+		"Content-Type: text/plain\r\n\r\n"+
+		"Numbers attached.\r\n")
+	b, tg := newTestBot(t)
+	acct := &Account{
+		ChatID: 99, Name: "default", Username: "username", Password: "password",
+		IMAPHost: host, IMAPPort: port, IMAPTLS: mail.TLSNone,
+	}
+
+	c, err := mail.DialIMAP(acct.imapDialConfig(), b.imapLogin(acct))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	status, err := c.Select("INBOX", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the message with UID 6 was there before the mailbox was watched
+	if err := b.setIMAPLastUID(acct.ID, "INBOX", status.UidValidity, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	tg.failSends = 1
+	if err := b.forwardNew(acct, c, "INBOX", status.UidValidity, status.UidNext); err == nil {
+		t.Fatal("forwardNew succeeded although Telegram refused the message")
+	}
+	if last, _ := b.imapLastUID(acct.ID, "INBOX", status.UidValidity); last != 6 {
+		t.Fatalf("last UID moved to %d after a failed post", last)
+	}
+	var posted sql.NullInt64
+	if err := b.db.QueryRow("SELECT tg_message_id FROM inbound_emails WHERE uid = 7").Scan(&posted); err != nil {
+		t.Fatal(err)
+	}
+	if posted.Valid {
+		t.Fatal("message recorded as posted after a failed post")
+	}
+
+	for range 2 {
+		if err := b.forwardNew(acct, c, "INBOX", status.UidValidity, status.UidNext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last, _ := b.imapLastUID(acct.ID, "INBOX", status.UidValidity); last != 7 {
+		t.Fatalf("last UID = %d, want 7", last)
+	}
+	sent := tg.sent("sendMessage")
+	if len(sent) != 1 {
+		t.Fatalf("message posted %d times, want once", len(sent))
+	}
+	if got := sent[0].Params["chat_id"]; got != "99" {
+		t.Errorf("posted to chat %s, want 99", got)
+	}
+	if err := b.db.QueryRow("SELECT tg_message_id FROM inbound_emails WHERE uid = 7").Scan(&posted); err != nil || !posted.Valid {
+		t.Fatalf("message not recorded as posted: %v", err)
+	}
+}
+
+func TestForwardNewPostsPlaceholderForUnparsableMessage(t *testing.T) {
+	host, port := startIMAP(t,
+		"From: Alice <alice@example.com>\r\nSubject: First\r\n\r\nOne.\r\n",
+		"From: Carol <carol@example.com>\r\nSubject: Second\r\nContent-Transfer-Encoding: x-unheard-of\r\n\r\nTwo.\r\n",
+		"From: Bob <bob@example.com>\r\nSubject: Third\r\n\r\nThree.\r\n")
+	b, tg := newTestBot(t)
+	acct := &Account{
+		ChatID: 99, Name: "work", Username: "username", Password: "password",
+		IMAPHost: host, IMAPPort: port, IMAPTLS: mail.TLSNone,
+	}
+	c, err := mail.DialIMAP(acct.imapDialConfig(), b.imapLogin(acct))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	status, err := c.Select("INBOX", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setIMAPLastUID(acct.ID, "INBOX", status.UidValidity, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.forwardNew(acct, c, "INBOX", status.UidValidity, status.UidNext); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := b.imapLastUID(acct.ID, "INBOX", status.UidValidity); last != 9 {
+		t.Fatalf("last UID = %d, want 9", last)
+	}
+	sent := tg.sent("sendMessage")
+	if len(sent) != 3 {
+		t.Fatalf("%d messages posted, want 3", len(sent))
+	}
+	for i, want := range []string{"Subject: First", "Could not parse message 8 from INBOX (work)", "Subject: Third"} {
+		if !strings.Contains(sent[i].Params["text"], want) {
+			t.Errorf("post %d = %q, want it to contain %q", i, sent[i].Params["text"], want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Every wizard decision can be typed or taken with an inline button. Button
+// data is "c:<action>" for the compose session and "d:<action>:<id>" for
+// drafts; a compose button pressed at another step than the one it was shown
+// for, or on another message than the session's latest keyboard, is stale and
+// only loses its keyboard.
+
+const (
+	cbAttach    = "c:attach"
+	cbNoAttach  = "c:noattach"
+	cbSkip      = "c:skip"
+	cbInline    = "c:inline"
+	cbAsFile    = "c:asfile"
+	cbSend      = "c:send"
+	cbSchedule  = "c:schedule"
+	cbUnsched   = "c:unschedule"
+	cbDraft     = "c:draft"
+	cbEncrypt   = "c:encrypt"
+	cbZip       = "c:zip"
+	cbLink      = "c:link"
+	cbIn1h      = "c:in1h"
+	cbTomorrow9 = "c:tomorrow9"
+	cbOtherTime = "c:othertime"
+	cbBack      = "c:back"
+
+	cbEditTo      = "c:edit:to"
+	cbEditSubject = "c:edit:subject"
+	cbEditBody    = "c:edit:body"
+	cbEditFiles   = "c:edit:files"
+	cbRemoveFiles = "c:removefiles"
+)
+
+// callbackSteps is the session step each compose button belongs to
+var callbackSteps = map[string]composeStep{
+	cbAttach: stepAttachAsk, cbNoAttach: stepAttachAsk,
+	cbSkip: stepUpload, cbRemoveFiles: stepUpload,
+	cbInline: stepPlaceImage, cbAsFile: stepPlaceImage,
+	cbSend: stepPreview, cbSchedule: stepPreview, cbDraft: stepPreview, cbEncrypt: stepPreview, cbZip: stepPreview, cbLink: stepPreview,
+	cbIn1h: stepPreview, cbTomorrow9: stepPreview, cbOtherTime: stepPreview, cbUnsched: stepPreview, cbBack: stepPreview,
+	cbEditTo: stepPreview, cbEditSubject: stepPreview, cbEditBody: stepPreview, cbEditFiles: stepPreview,
+}
+
+var (
+	attachKeyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("📎 Attach a file", cbAttach),
+		tgbotapi.NewInlineKeyboardButtonData("Skip", cbNoAttach),
+	))
+	uploadKeyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Done, no more files", cbSkip),
+	))
+	imageKeyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🖼 Inline", cbInline),
+		tgbotapi.NewInlineKeyboardButtonData("📎 Attach", cbAsFile),
+	))
+	scheduleKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("In 1 hour", cbIn1h),
+			tgbotapi.NewInlineKeyboardButtonData("Tomorrow 9:00", cbTomorrow9),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🕒 Other time", cbOtherTime),
+			tgbotapi.NewInlineKeyboardButtonData("📤 Send immediately", cbUnsched),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("« Back", cbBack),
+		),
+	)
+	editFilesKeyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🗑 Remove attachments", cbRemoveFiles),
+		tgbotapi.NewInlineKeyboardButtonData("Keep them", cbSkip),
+	))
+)
+
+// previewKeyboard offers the decisions available at the preview and an Edit
+// button for each field
+func (b *Bot) previewKeyboard(session *EmailSession) tgbotapi.InlineKeyboardMarkup {
+	encrypt := "🔐 Encrypt"
+	if session.Encrypt {
+		encrypt = "🔓 Don't encrypt"
+	}
+	options := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(encrypt, cbEncrypt)}
+	if len(sessionHashes(session)) > 0 {
+		options = append(options, tgbotapi.NewInlineKeyboardButtonData("🗜 Zip", cbZip))
+	}
+	if session.FileHash != "" && b.linksEnabled() {
+		link := "🔗 As link"
+		if session.AsLink {
+			link = "📎 As attachment"
+		}
+		options = append(options, tgbotapi.NewInlineKeyboardButtonData(link, cbLink))
+	}
+	send := tgbotapi.NewInlineKeyboardButtonData("📤 Send now", cbSend)
+	if session.Schedule != "" {
+		send = tgbotapi.NewInlineKeyboardButtonData("⏰ Schedule for "+session.Schedule, cbSend)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(send, tgbotapi.NewInlineKeyboardButtonData("💾 Save draft", cbDraft)),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ To", cbEditTo),
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Subject", cbEditSubject),
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Body", cbEditBody),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Attachments", cbEditFiles),
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Schedule", cbSchedule),
+		),
+		options,
+	)
+}
+
+// schedulePreset returns the send time of a quick schedule button, in the
+// same UTC "YYYY-MM-DD HH:MM" form as typed times
+func schedulePreset(data string, now time.Time) string {
+	now = now.UTC()
+	if data == cbIn1h {
+		return now.Add(time.Hour).Format("2006-01-02 15:04")
+	}
+	tomorrow := now.AddDate(0, 0, 1)
+	return time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 9, 0, 0, 0, time.UTC).Format("2006-01-02 15:04")
+}
+
+// handleCallback dispatches an inline button press
+func (b *Bot) handleCallback(cq *tgbotapi.CallbackQuery) {
+	if cq.Message == nil {
+		b.API.Request(tgbotapi.NewCallback(cq.ID, ""))
+		return
+	}
+	if strings.HasPrefix(cq.Data, "d:") {
+		b.handleDraftCallback(cq)
+		return
+	}
+
+	chatID := cq.Message.Chat.ID
+	session, ok := b.getSession(chatID)
+	step, known := callbackSteps[cq.Data]
+	if !ok || !known || session.Step != step || cq.Message.MessageID != session.KeyboardMsgID {
+		b.API.Request(tgbotapi.NewCallback(cq.ID, "This button is no longer active."))
+		b.clearButtons(cq.Message)
+		return
+	}
+	b.API.Request(tgbotapi.NewCallback(cq.ID, ""))
+
+	switch cq.Data {
+	case cbSchedule:
+		b.API.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, cq.Message.MessageID, scheduleKeyboard))
+		return
+	case cbBack:
+		b.API.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, cq.Message.MessageID, b.previewKeyboard(session)))
+		return
+	}
+
+	b.clearButtons(cq.Message)
+	session.KeyboardMsgID = 0
+	switch cq.Data {
+	case cbAttach, cbNoAttach:
+		b.chooseAttach(chatID, session, cq.Data == cbAttach)
+	case cbSkip:
+		b.sendPreview(chatID, session)
+	case cbInline, cbAsFile:
+		if session.Pending == nil {
+			session.Step = stepUpload
+			return
+		}
+		b.placeImage(chatID, session, cq.Data == cbInline)
+	case cbSend:
+		if session.Schedule != "" {
+			b.scheduleAt(chatID, session, session.Schedule)
+		} else {
+			b.sendNow(chatID, session)
+		}
+	case cbIn1h, cbTomorrow9:
+		b.setSchedule(chatID, session, schedulePreset(cq.Data, time.Now()))
+	case cbUnsched:
+		session.Schedule = ""
+		b.sendPreview(chatID, session)
+	case cbOtherTime:
+		session.Step = stepSendTime
+		b.API.Send(tgbotapi.NewMessage(chatID, "🕒 Send the time as YYYY-MM-DD HH:MM (UTC)."))
+	case cbEditTo, cbEditSubject, cbEditBody, cbEditFiles:
+		b.startEdit(chatID, session, strings.TrimPrefix(cq.Data, "c:edit:"))
+	case cbRemoveFiles:
+		b.removeAttachments(chatID, session)
+	case cbDraft:
+		b.saveDraft(chatID, session)
+	case cbEncrypt:
+		b.toggleEncryption(chatID, session)
+	case cbZip:
+		b.zipAttachment(chatID, session)
+	case cbLink:
+		b.toggleLink(chatID, session)
+	}
+}
+
+// clearButtons removes the inline keyboard from a message once it was used
+func (b *Bot) clearButtons(m *tgbotapi.Message) {
+	b.clearButtonsOf(m.Chat.ID, m.MessageID)
+}
+
+func (b *Bot) clearButtonsOf(chatID int64, messageID int) {
+	empty := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	b.API.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, empty))
+}
+
+// sendKeyboard posts a wizard message with buttons and makes it the session's
+// only live keyboard, removing the buttons of the previous one
+func (b *Bot) sendKeyboard(session *EmailSession, m tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	sent, err := b.API.Send(m)
+	if err != nil {
+		return sent, err
+	}
+	if session.KeyboardMsgID != 0 && session.KeyboardMsgID != sent.MessageID {
+		b.clearButtonsOf(m.ChatID, session.KeyboardMsgID)
+	}
+	session.KeyboardMsgID = sent.MessageID
+	return sent, nil
+}
+
+// callbackID parses the numeric id at the end of button data such as "d:open:12"
+func callbackID(data string) (int64, bool) {
+	i := strings.LastIndexByte(data, ':')
+	id, err := strconv.ParseInt(data[i+1:], 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,197 @@
+package bot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// defaultLinkTTL is how long a download link works when DOWNLOAD_LINK_TTL is not set
+const defaultLinkTTL = 7 * 24 * time.Hour
+
+func linkOwner(token string) string { return "link:" + token }
+
+// linksEnabled reports whether the HTTP server can host download links
+func (b *Bot) linksEnabled() bool {
+	return b.httpAddr != "" && b.publicURL != ""
+}
+
+// createDownloadLink hosts stored content under a random token and returns
+// the token and its URL
+func (b *Bot) createDownloadLink(hash, name, ctype string) (token, url string, expires time.Time, err error) {
+	var raw [24]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", "", time.Time{}, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw[:])
+	expires = time.Now().Add(b.linkTTL).UTC()
+
+	b.dbMu.Lock()
+	_, err = b.db.Exec(`INSERT INTO download_links (token, sha256, name, content_type, expires_at, max_downloads, downloads, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, 0, ?)`,
+		token, hash, name, ctype, expires.Format(time.RFC3339), b.linkMaxDownloads, time.Now().UTC().Format(time.RFC3339))
+	b.dbMu.Unlock()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	b.retainAttachment(hash, linkOwner(token))
+	return token, b.publicLink("/d/" + token), expires, nil
+}
+
+// deleteDownloadLink removes a link and releases its file
+func (b *Bot) deleteDownloadLink(token string) {
+	b.releaseAttachments(linkOwner(token))
+	b.dbMu.Lock()
+	_, _ = b.db.Exec("DELETE FROM download_links WHERE token = ?", token)
+	b.dbMu.Unlock()
+}
+
+// withAttachmentLink returns a copy of the session whose attachment is replaced
+// by a download link at the end of the body, and the link's token. Sessions
+// without the link option are returned as they are, with an empty token.
+// Run every check that can refuse the send first; a link for an email that
+// was not delivered must be removed with deleteDownloadLink.
+func (b *Bot) withAttachmentLink(session *EmailSession) (*EmailSession, string, error) {
+	if !session.AsLink || session.FileHash == "" {
+		return session, "", nil
+	}
+	if !b.linksEnabled() {
+		return nil, "", fmt.Errorf("download links need HTTP_ADDR and PUBLIC_URL")
+	}
+	token, url, expires, err := b.createDownloadLink(session.FileHash, session.FileName, session.FileType)
+	if err != nil {
+		return nil, "", fmt.Errorf("create download link: %w", err)
+	}
+	s := *session
+	size, until := formatMB(b.attachmentSize(s.FileHash)), expires.Format("2006-01-02 15:04 MST")
+	if looksLikeHTML(s.Body) {
+		s.Body += fmt.Sprintf(`<p>📎 <a href="%s">%s</a> (%s, available until %s)</p>`, url, html.EscapeString(s.FileName), size, until)
+	} else {
+		s.Body += fmt.Sprintf("\n\n📎 %s (%s): %s (available until %s)", s.FileName, size, url, until)
+	}
+	s.FileHash, s.FileName, s.FileType = "", "", ""
+	return &s, token, nil
+}
+
+// toggleLink switches between attaching the file and sending a download link
+func (b *Bot) toggleLink(chatID int64, session *EmailSession) {
+	if session.FileHash == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "There is no attachment to share as a link."))
+		return
+	}
+	if !b.linksEnabled() {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Download links are not available: the bot needs HTTP_ADDR and PUBLIC_URL to host files."))
+		return
+	}
+	session.AsLink = !session.AsLink
+	b.sendPreview(chatID, session)
+}
+
+// linkUsable selects download links that have not expired or been used up
+const linkUsable = "token = ? AND expires_at > ? AND (max_downloads = 0 OR downloads < max_downloads)"
+
+// serveDownload handles GET and HEAD /d/<token>
+func (b *Bot) serveDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/d/")
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var n int64
+	var err error
+	if fullDownload(r) {
+		// count the download only while the link is valid, in one statement
+		// so concurrent requests can't exceed the limit
+		var res sql.Result
+		b.dbMu.Lock()
+		res, err = b.db.Exec("UPDATE download_links SET downloads = downloads + 1 WHERE "+linkUsable, token, now)
+		b.dbMu.Unlock()
+		if err == nil {
+			n, _ = res.RowsAffected()
+		}
+	} else {
+		err = b.db.QueryRow("SELECT COUNT(*) FROM download_links WHERE "+linkUsable, token, now).Scan(&n)
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "This link has expired or does not exist.", http.StatusGone)
+		return
+	}
+
+	var hash, name, ctype string
+	if err := b.db.QueryRow("SELECT sha256, name, content_type FROM download_links WHERE token = ?", token).Scan(&hash, &name, &ctype); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := b.readAttachment(hash)
+	if err != nil {
+		log.Printf("download %s: %v", token, err)
+		http.Error(w, "This file is no longer available.", http.StatusGone)
+		return
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// fullDownload reports whether r fetches the file from its first byte. Only
+// those count as downloads: a HEAD, or a Range request resuming further in,
+// is part of a download already counted.
+func fullDownload(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	rng := r.Header.Get("Range")
+	if rng == "" {
+		return true
+	}
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	return ok && strings.HasPrefix(strings.TrimSpace(spec), "0-")
+}
+
+// expireLinks deletes expired or used up links and releases their files
+func (b *Bot) expireLinks() {
+	now := time.Now().UTC().Format(time.RFC3339)
+	rows, err := b.db.Query(`SELECT token FROM download_links
+	WHERE expires_at <= ? OR (max_downloads > 0 AND downloads >= max_downloads)`, now)
+	if err != nil {
+		log.Println("expire links:", err)
+		return
+	}
+	var tokens []string
+	for rows.Next() {
+		var t string
+		if rows.Scan(&t) == nil {
+			tokens = append(tokens, t)
+		}
+	}
+	rows.Close()
+
+	for _, t := range tokens {
+		b.deleteDownloadLink(t)
+	}
+}
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+func TestDownloadCountsOnlyFullGets(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.linkTTL, b.linkMaxDownloads = time.Hour, 2
+	hash, err := b.storeAttachment([]byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, _, err := b.createDownloadLink(hash, "digits.txt", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(method, rng string) (int, string) {
+		t.Helper()
+		r := httptest.NewRequest(method, "/d/"+token, nil)
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		b.serveDownload(w, r)
+		body, _ := io.ReadAll(w.Result().Body)
+		return w.Code, string(body)
+	}
+	downloads := func() int {
+		var n int
+		b.db.QueryRow("SELECT downloads FROM download_links WHERE token = ?", token).Scan(&n)
+		return n
+	}
+
+	steps := []struct {
+		method, rng string
+		code        int
+		body        string
+		downloads   int
+	}{
+		{http.MethodHead, "", http.StatusOK, "", 0},
+		{http.MethodGet, "bytes=5-", http.StatusPartialContent, "56789", 0},
+		{http.MethodGet, "", http.StatusOK, "0123456789", 1},
+		{http.MethodHead, "", http.StatusOK, "", 1},
+		{http.MethodGet, "bytes=0-3", http.StatusPartialContent, "0123", 2},
+		// the limit is reached: the link is gone, counted or not (the recorder
+		// keeps the body of a HEAD response, a server would drop it)
+		{http.MethodHead, "", http.StatusGone, "This link has expired or does not exist.\n", 2},
+		{http.MethodGet, "bytes=4-", http.StatusGone, "This link has expired or does not exist.\n", 2},
+		{http.MethodGet, "", http.StatusGone, "This link has expired or does not exist.\n", 2},
+		{http.MethodPost, "", http.StatusMethodNotAllowed, "method not allowed\n", 2},
+	}
+	for i, s := range steps {
+		code, body := fetch(s.method, s.rng)
+		if code != s.code || body != s.body {
+			t.Errorf("step %d: %s %q = %d %q, want %d %q", i, s.method, s.rng, code, body, s.code, s.body)
+		}
+		if n := downloads(); n != s.downloads {
+			t.Errorf("step %d: %s %q: %d downloads counted, want %d", i, s.method, s.rng, n, s.downloads)
+		}
+	}
+}
+
+func TestRefusedSendLeavesNoLink(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.linkTTL = time.Hour
+	b.httpAddr, b.publicURL = "127.0.0.1:0", "https://bot.example.com"
+	hash, err := b.storeAttachment([]byte("quarterly numbers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &EmailSession{ChatID: 5, To: "alice@example.com", Subject: "Report", Body: "Numbers below.",
+		FileHash: hash, FileName: "report.txt", FileType: "text/plain", AsLink: true}
+	links := func() (rows, refs int) {
+		t.Helper()
+		b.db.QueryRow("SELECT COUNT(*) FROM download_links").Scan(&rows)
+		b.db.QueryRow("SELECT COUNT(*) FROM attachment_refs WHERE owner LIKE 'link:%'").Scan(&refs)
+		return rows, refs
+	}
+
+	// refused before anything is sent: no key for an encrypted send
+	session.Encrypt = true
+	if err := b.sendMailMulti(context.Background(), session); err == nil {
+		t.Fatal("encrypted send without a key succeeded")
+	}
+	if rows, refs := links(); rows != 0 || refs != 0 {
+		t.Fatalf("refused send left %d links and %d refs", rows, refs)
+	}
+
+	// refused by the server: nobody got the link
+	session.Encrypt = false
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.defaultAccount.Host, b.defaultAccount.Port = "127.0.0.1", ln.Addr().(*net.TCPAddr).Port
+	b.defaultAccount.TLSMode = mail.TLSNone
+	ln.Close()
+	if err := b.sendMailMulti(context.Background(), session); err == nil {
+		t.Fatal("send to a closed port succeeded")
+	}
+	if rows, refs := links(); rows != 0 || refs != 0 {
+		t.Fatalf("failed send left %d links and %d refs", rows, refs)
+	}
+
+	sink := startSMTP(t, b)
+	if err := b.sendMailMulti(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	if rows, refs := links(); rows != 1 || refs != 1 {
+		t.Fatalf("delivered send has %d links and %d refs, want 1", rows, refs)
+	}
+	if _, msgs := sink.stats(); len(msgs) != 1 || !strings.Contains(msgs[0], "https://bot.example.com/d/") {
+		t.Errorf("delivered message does not carry the link: %v", msgs)
+	}
+}
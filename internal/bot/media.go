@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxTelegramDownload is the largest file the Bot API lets bots download
+const maxTelegramDownload = 20 << 20
+
+// telegramMedia is the file carried by a Telegram message
+type telegramMedia struct {
+	FileID   string
+	Name     string
+	MimeType string
+	// Size is 0 when Telegram did not report it
+	Size int
+}
+
+// inlineImage is a photo embedded in an HTML body, shown where the HTML
+// references cid:<ContentID>
+type inlineImage struct {
+	ContentID string
+	Hash      string
+	Name      string
+	Type      string
+}
+
+var htmlTagRe = regexp.MustCompile(`(?i)<(html|body|p|div|br|table|img|h[1-6]|ul|ol|a|b|i|strong|em|span)\b[^>]*>`)
+
+// looksLikeHTML reports whether an email body is written in HTML
+func looksLikeHTML(body string) bool {
+	return htmlTagRe.MatchString(body)
+}
+
+// isInlineImage reports whether the media can be shown inside an HTML body
+func isInlineImage(m *telegramMedia) bool {
+	switch m.MimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// messageMedia returns the file attached to msg: a document, the largest size
+// of a photo, a voice note, audio, video, video note or sticker. Files without
+// a name get one generated from their kind and unique ID.
+func messageMedia(msg *tgbotapi.Message) (*telegramMedia, bool) {
+	var m *telegramMedia
+	switch {
+	case msg.Document != nil:
+		d := msg.Document
+		m = &telegramMedia{FileID: d.FileID, Name: d.FileName, MimeType: d.MimeType, Size: d.FileSize}
+		if m.Name == "" {
+			m.Name = "document_" + d.FileUniqueID + extensionFor(d.MimeType, "")
+		}
+	case len(msg.Photo) > 0:
+		p := msg.Photo[len(msg.Photo)-1]
+		m = &telegramMedia{FileID: p.FileID, Name: "photo_" + p.FileUniqueID + ".jpg", MimeType: "image/jpeg", Size: p.FileSize}
+	case msg.Voice != nil:
+		v := msg.Voice
+		m = &telegramMedia{FileID: v.FileID, Name: "voice_" + v.FileUniqueID + extensionFor(v.MimeType, ".ogg"), MimeType: orName(v.MimeType, "audio/ogg"), Size: v.FileSize}
+	case msg.Audio != nil:
+		a := msg.Audio
+		m = &telegramMedia{FileID: a.FileID, Name: a.FileName, MimeType: orName(a.MimeType, "audio/mpeg"), Size: a.FileSize}
+		if m.Name == "" {
+			m.Name = "audio_" + a.FileUniqueID + extensionFor(m.MimeType, ".mp3")
+		}
+	case msg.Video != nil:
+		v := msg.Video
+		m = &telegramMedia{FileID: v.FileID, Name: v.FileName, MimeType: orName(v.MimeType, "video/mp4"), Size: v.FileSize}
+		if m.Name == "" {
+			m.Name = "video_" + v.FileUniqueID + extensionFor(m.MimeType, ".mp4")
+		}
+	case msg.VideoNote != nil:
+		v := msg.VideoNote
+		m = &telegramMedia{FileID: v.FileID, Name: "videonote_" + v.FileUniqueID + ".mp4", MimeType: "video/mp4", Size: v.FileSize}
+	case msg.Sticker != nil:
+		st := msg.Sticker
+		ext, mimeType := ".webp", "image/webp"
+		switch {
+		case st.IsVideo:
+			ext, mimeType = ".webm", "video/webm"
+		case st.IsAnimated:
+			ext, mimeType = ".tgs", "application/x-tgsticker"
+		}
+		m = &telegramMedia{FileID: st.FileID, Name: "sticker_" + st.FileUniqueID + ext, MimeType: mimeType, Size: st.FileSize}
+	default:
+		return nil, false
+	}
+	if m.MimeType == "" {
+		m.MimeType = mime.TypeByExtension(filepath.Ext(m.Name))
+	}
+	return m, true
+}
+
+// extensionFor picks a file extension for a MIME type, fallback when unknown
+func extensionFor(mimeType, fallback string) string {
+	switch strings.ToLower(mimeType) {
+	case "audio/ogg":
+		return ".ogg"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/mp4", "audio/x-m4a":
+		return ".m4a"
+	case "video/mp4":
+		return ".mp4"
+	case "video/quicktime":
+		return ".mov"
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return exts[0]
+	}
+	return fallback
+}
+
+func orName(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// tooLargeError explains that a file is over the Bot API download limit
+func tooLargeError(name string, size int) error {
+	if size > 0 {
+		return fmt.Errorf("%s is %.1f MB, but Telegram only lets bots download files up to %d MB; send a smaller file or share a link instead", name, float64(size)/(1<<20), maxTelegramDownload>>20)
+	}
+	return fmt.Errorf("%s is larger than the %d MB Telegram lets bots download; send a smaller file or share a link instead", name, maxTelegramDownload>>20)
+}
+
+// saveMedia downloads the file, scans it and adds it to the attachment store,
+// returning its hash. Files the scanner flags or cannot check are refused.
+func (b *Bot) saveMedia(chatID int64, m *telegramMedia) (string, error) {
+	if m.Size > maxTelegramDownload {
+		return "", tooLargeError(m.Name, m.Size)
+	}
+	data, err := b.downloadFile(m.FileID)
+	if err != nil {
+		if strings.Contains(err.Error(), "file is too big") {
+			return "", tooLargeError(m.Name, m.Size)
+		}
+		return "", fmt.Errorf("download %s: %w", m.Name, err)
+	}
+	return b.acceptUpload(chatID, m.Name, data)
+}
+
+// acceptUpload scans an uploaded file and adds it to the attachment store,
+// returning its hash
+func (b *Bot) acceptUpload(chatID int64, name string, data []byte) (string, error) {
+	res, err := b.scanner.Scan(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("scan: %s from chat %d: %v", name, chatID, err)
+		return "", fmt.Errorf("%s could not be checked for malware, please try again later", name)
+	}
+	if res.Infected {
+		log.Printf("scan: rejected %s from chat %d: %s", name, chatID, res.Signature)
+		return "", fmt.Errorf("%s was rejected: malware detected (%s)", name, res.Signature)
+	}
+	return b.storeAttachment(data)
+}
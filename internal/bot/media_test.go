@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMessageMediaSticker(t *testing.T) {
+	tests := []struct {
+		name     string
+		sticker  tgbotapi.Sticker
+		wantName string
+		wantType string
+	}{
+		{"static", tgbotapi.Sticker{}, "sticker_u1.webp", "image/webp"},
+		{"animated", tgbotapi.Sticker{IsAnimated: true}, "sticker_u1.tgs", "application/x-tgsticker"},
+		{"video", tgbotapi.Sticker{IsVideo: true}, "sticker_u1.webm", "video/webm"},
+	}
+	for _, tt := range tests {
+		st := tt.sticker
+		st.FileID, st.FileUniqueID = "f1", "u1"
+		m, ok := messageMedia(&tgbotapi.Message{Sticker: &st})
+		if !ok {
+			t.Fatalf("%s: sticker not recognised as media", tt.name)
+		}
+		if m.Name != tt.wantName || m.MimeType != tt.wantType {
+			t.Errorf("%s: got %s %s, want %s %s", tt.name, m.Name, m.MimeType, tt.wantName, tt.wantType)
+		}
+		if isInlineImage(m) != (tt.wantType == "image/webp") {
+			t.Errorf("%s: isInlineImage = %v", tt.name, isInlineImage(m))
+		}
+	}
+}
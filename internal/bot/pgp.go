@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+// maxKeyFileSize bounds uploaded public key files
+const maxKeyFileSize = 1 << 20
+
+// isPGPKeyFile reports whether an uploaded document looks like an armored public key
+func isPGPKeyFile(doc *tgbotapi.Document) bool {
+	ext := strings.ToLower(filepath.Ext(doc.FileName))
+	return ext == ".asc" || doc.MimeType == "application/pgp-keys"
+}
+
+// handlePGPKeyUpload stores the public keys of an uploaded .asc file for every email address they carry
+func (b *Bot) handlePGPKeyUpload(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	if msg.Document.FileSize > maxKeyFileSize {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Key file is too large."))
+		return
+	}
+	data, err := b.downloadFile(msg.Document.FileID)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to download key: "+err.Error()))
+		return
+	}
+	keys, err := mail.ReadPGPKeys(data)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Not a valid OpenPGP public key: "+err.Error()))
+		return
+	}
+
+	var stored []string
+	for _, e := range keys {
+		if _, ok := e.EncryptionKey(time.Now()); !ok {
+			continue
+		}
+		var armored bytes.Buffer
+		if err := e.Serialize(&armored); err != nil {
+			continue
+		}
+		for _, email := range mail.PGPKeyEmails(e) {
+			if err := b.savePGPKey(chatID, email, mail.PGPFingerprint(e), armored.Bytes()); err != nil {
+				b.API.Send(tgbotapi.NewMessage(chatID, "Failed to store key: "+err.Error()))
+				return
+			}
+			stored = append(stored, email)
+		}
+	}
+	if len(stored) == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No usable encryption key with an email address found in that file."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🔑 Stored PGP key for "+strings.Join(stored, ", ")))
+}
+
+func (b *Bot) savePGPKey(chatID int64, email, fingerprint string, key []byte) error {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	_, err := b.db.Exec(`INSERT INTO pgp_keys (chat_id, email, fingerprint, public_key, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(chat_id, email) DO UPDATE SET fingerprint = excluded.fingerprint, public_key = excluded.public_key`,
+		chatID, email, fingerprint, key, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// pgpKey loads the chat's public key for an email address, nil when there is none
+func (b *Bot) pgpKey(chatID int64, email string) (*openpgp.Entity, error) {
+	var key []byte
+	err := b.db.QueryRow("SELECT public_key FROM pgp_keys WHERE chat_id = ? AND email = ?", chatID, strings.ToLower(email)).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, err := openpgp.ReadKeyRing(bytes.NewReader(key))
+	if err != nil || len(keys) == 0 {
+		return nil, fmt.Errorf("stored key for %s is unreadable: %v", email, err)
+	}
+	return keys[0], nil
+}
+
+// missingPGPKeys lists the recipients without a stored public key
+func (b *Bot) missingPGPKeys(chatID int64, recipients []string) []string {
+	var missing []string
+	for _, to := range recipients {
+		if e, err := b.pgpKey(chatID, to); err != nil || e == nil {
+			missing = append(missing, to)
+		}
+	}
+	return missing
+}
+
+func (b *Bot) cmdListKeys(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	rows, err := b.db.Query("SELECT email, fingerprint FROM pgp_keys WHERE chat_id = ? ORDER BY email", chatID)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to query keys: "+err.Error()))
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var email, fp string
+		_ = rows.Scan(&email, &fp)
+		lines = append(lines, email+" — "+fp)
+	}
+	if len(lines) == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No PGP keys stored. Send a contact's public key as an .asc file to add one."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🔑 PGP keys:\n"+strings.Join(lines, "\n")))
+}
+
+func (b *Bot) cmdDeleteKey(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	email := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	if email == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /delkey <email>"))
+		return
+	}
+	b.dbMu.Lock()
+	res, err := b.db.Exec("DELETE FROM pgp_keys WHERE chat_id = ? AND email = ?", chatID, email)
+	b.dbMu.Unlock()
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to delete key: "+err.Error()))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No key stored for "+email+"."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🗑 Key for "+email+" deleted."))
+}
+
+// toggleEncryption flips the session's encrypt flag and warns about recipients without keys
+func (b *Bot) toggleEncryption(chatID int64, session *EmailSession) {
+	session.Encrypt = !session.Encrypt
+	if session.Encrypt {
+		if missing := b.missingPGPKeys(chatID, allRecipients(session)); len(missing) > 0 {
+			b.API.Send(tgbotapi.NewMessage(chatID, "⚠️ No PGP key for "+strings.Join(missing, ", ")+
+				". The email will not be sent until you upload their key (.asc) or turn encryption off."))
+		}
+	}
+	b.sendPreview(chatID, session)
+}
+
+// applyPGP sets up signing of m and its encryption to the recipients it is delivered to
+func (b *Bot) applyPGP(m *mail.Message, acct *Account, session *EmailSession, recipients []string) error {
+	signer, err := acct.pgpSigner()
+	if err != nil {
+		return err
+	}
+	m.PGPSigner = signer
+	if !session.Encrypt {
+		return nil
+	}
+	for _, to := range recipients {
+		key, err := b.pgpKey(session.ChatID, to)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			// never fall back to plaintext when encryption was asked for
+			return fmt.Errorf("no PGP key for %s, refusing to send unencrypted", to)
+		}
+		m.PGPRecipients = append(m.PGPRecipients, key)
+	}
+	return nil
+}
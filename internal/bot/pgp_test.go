@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+// storeTestPGPKey generates a key for email and stores its public half for the chat
+func storeTestPGPKey(t *testing.T, b *Bot, chatID int64, email string) {
+	t.Helper()
+	e, err := openpgp.NewEntity("Contact", "", email, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub bytes.Buffer
+	if err := e.Serialize(&pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.savePGPKey(chatID, email, mail.PGPFingerprint(e), pub.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedSendRefusedWithoutEveryKey(t *testing.T) {
+	b, _ := newTestBot(t)
+	sink := startSMTP(t, b)
+	storeTestPGPKey(t, b, 5, "alice@example.com")
+
+	session := &EmailSession{
+		ChatID:  5,
+		To:      "alice@example.com, bob@example.com",
+		Subject: "Secret",
+		Body:    "The launch code is 0000.",
+		Encrypt: true,
+	}
+	err := b.sendMailMulti(context.Background(), session)
+	if err == nil || !strings.Contains(err.Error(), "bob@example.com") {
+		t.Fatalf("send error = %v, want a refusal naming bob@example.com", err)
+	}
+	if strings.Contains(err.Error(), "alice@") {
+		t.Errorf("refusal names alice although her key is stored: %v", err)
+	}
+	if conns, msgs := sink.stats(); conns != 0 || len(msgs) != 0 {
+		t.Fatalf("%d SMTP connections and %d messages for a refused send", conns, len(msgs))
+	}
+
+	// with bob's key the same send goes out, encrypted to each recipient
+	storeTestPGPKey(t, b, 5, "bob@example.com")
+	if err := b.sendMailMulti(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	_, msgs := sink.stats()
+	if len(msgs) != 2 {
+		t.Fatalf("%d messages delivered, want 2", len(msgs))
+	}
+	for _, m := range msgs {
+		if !strings.Contains(m, "multipart/encrypted") || strings.Contains(m, "launch code") {
+			t.Errorf("delivered message is not encrypted:\n%s", m)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestSendPreviewEscapesAndTruncatesBody(t *testing.T) {
+	b, tg := newTestBot(t)
+	session := &EmailSession{
+		ChatID:  5,
+		To:      "first_last@example.com",
+		Subject: "Price *50% off",
+		Body:    "see `code and a_b\n" + strings.Repeat("x", 5000),
+	}
+	b.sendPreview(5, session)
+
+	sent := tg.sent("sendMessage")
+	if len(sent) != 1 {
+		t.Fatalf("%d messages sent, want the preview", len(sent))
+	}
+	text := sent[0].Params["text"]
+	if n := utf8.RuneCountInString(text); n > 4096 {
+		t.Errorf("preview is %d characters, over Telegram's limit", n)
+	}
+	for _, want := range []string{`first\_last`, `Price \*50%`, "see \\`code and a\\_b", "x…"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("preview lacks %q:\n%s", want, text)
+		}
+	}
+	if session.Step != stepPreview {
+		t.Errorf("step = %d, want the preview step", session.Step)
+	}
+}
+
+func TestSendPreviewFallsBackWhenRejected(t *testing.T) {
+	b, tg := newTestBot(t)
+	tg.failSends = 1
+	b.sendPreview(5, &EmailSession{ChatID: 5, To: "a@example.com", Subject: "Hi", Body: "Hello"})
+
+	sent := tg.sent("sendMessage")
+	if len(sent) != 1 {
+		t.Fatalf("%d messages sent after the preview failed, want a fallback", len(sent))
+	}
+	if sent[0].Params["parse_mode"] != "" || sent[0].Params["reply_markup"] == "" {
+		t.Errorf("fallback = %+v, want plain text with the preview buttons", sent[0].Params)
+	}
+}
+
+func TestOnlyLatestPreviewButtonsAreLive(t *testing.T) {
+	b, tg := newTestBot(t)
+	session := &EmailSession{ChatID: 5, To: "a@example.com", Subject: "Hi", Body: "Hello"}
+	b.setSession(5, session)
+
+	b.sendPreview(5, session)
+	first := session.KeyboardMsgID
+	b.sendPreview(5, session)
+	second := session.KeyboardMsgID
+	if first == 0 || second == first {
+		t.Fatalf("keyboard message ids %d then %d, want two different previews", first, second)
+	}
+	edits := tg.sent("editMessageReplyMarkup")
+	if len(edits) != 1 || edits[0].Params["message_id"] != strconv.Itoa(first) {
+		t.Fatalf("edits = %+v, want the first preview's buttons removed", edits)
+	}
+
+	press := func(messageID int) string {
+		t.Helper()
+		before := len(tg.sent("answerCallbackQuery"))
+		b.handleCallback(&tgbotapi.CallbackQuery{
+			ID:      "q",
+			Data:    cbEncrypt,
+			Message: &tgbotapi.Message{MessageID: messageID, Chat: &tgbotapi.Chat{ID: 5}},
+		})
+		answers := tg.sent("answerCallbackQuery")
+		if len(answers) != before+1 {
+			t.Fatalf("%d callback answers, want one more", len(answers)-before)
+		}
+		return answers[len(answers)-1].Params["text"]
+	}
+	if text := press(first); !strings.Contains(text, "no longer active") || session.Encrypt {
+		t.Fatalf("old preview's button: answer %q, encrypt %v; want it refused", text, session.Encrypt)
+	}
+	if text := press(second); text != "" || !session.Encrypt {
+		t.Fatalf("latest preview's button: answer %q, encrypt %v; want it applied", text, session.Encrypt)
+	}
+	if session.KeyboardMsgID == second {
+		t.Error("the toggled preview was not replaced by a new one")
+	}
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+// inboundEmail is a received email as recorded when it was posted to the chat
+type inboundEmail struct {
+	AccountID int64
+	mail.InboundMessage
+}
+
+// inboundByTelegramMessage finds the email that was posted as the given chat message
+func (b *Bot) inboundByTelegramMessage(chatID int64, messageID int) (*inboundEmail, bool) {
+	var in inboundEmail
+	var refs string
+	err := b.db.QueryRow(`SELECT account_id, message_id, references_list, from_addr, reply_to, subject, body
+	FROM inbound_emails WHERE chat_id = ? AND tg_message_id = ?`, chatID, messageID).
+		Scan(&in.AccountID, &in.MessageID, &refs, &in.From, &in.ReplyTo, &in.Subject, &in.Text)
+	if err != nil {
+		return nil, false
+	}
+	in.References = strings.Fields(refs)
+	return &in, true
+}
+
+// startReply opens a compose session answering a forwarded email. The session
+// starts at the body step; text sent along with the Telegram reply is used as
+// the body right away.
+func (b *Bot) startReply(msg *tgbotapi.Message, in *inboundEmail) {
+	chatID := msg.Chat.ID
+	to := in.ReplyTo
+	if to == "" {
+		to = in.From
+	}
+	s := &EmailSession{
+		Step:      stepBody,
+		To:        to,
+		Subject:   replySubject(in.Subject),
+		ChatID:    chatID,
+		AccountID: in.AccountID,
+		Quote:     quoteText(in.From, in.Text),
+		CreatedAt: time.Now().UTC(),
+	}
+	if in.MessageID != "" {
+		s.InReplyTo = angleID(in.MessageID)
+		for _, r := range in.References {
+			s.References = append(s.References, angleID(r))
+		}
+		s.References = append(s.References, s.InReplyTo)
+	}
+	b.setSession(chatID, s)
+
+	b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("↩️ Replying to %s\nSubject: %s", to, s.Subject)))
+	if strings.TrimSpace(msg.Text) != "" {
+		b.handleConversation(msg)
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "📝 Body text of your reply? Send one or more messages, then /done."))
+}
+
+// replySubject prefixes "Re: " unless the subject already is a reply
+func replySubject(subject string) string {
+	if len(subject) >= 3 && strings.EqualFold(subject[:3], "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// quoteText renders the original message as a quoted block to append to a reply
+func quoteText(from, text string) string {
+	if text == "" {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = "> " + l
+	}
+	return "\n\n" + from + " wrote:\n" + strings.Join(lines, "\n")
+}
+
+// angleID wraps a message ID in angle brackets as used in threading headers
+func angleID(id string) string {
+	return "<" + strings.Trim(id, "<>") + ">"
+}
@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleAtRejectsUnusableTimes(t *testing.T) {
+	b, tg := newTestBot(t)
+	session := &EmailSession{ChatID: 5, Step: stepPreview, To: "a@example.com", Subject: "Hi", Body: "Hello"}
+	past := time.Now().UTC().Add(-time.Hour).Format("2006-01-02 15:04")
+	for _, when := range []string{"tomorrow at noon", past} {
+		b.scheduleAt(5, session, when)
+	}
+	var n int
+	b.db.QueryRow("SELECT COUNT(*) FROM scheduled_emails").Scan(&n)
+	if n != 0 {
+		t.Fatalf("%d emails scheduled for unusable times", n)
+	}
+	if session.Step != stepPreview || session.Schedule != "" {
+		t.Errorf("session left the preview: step %d, schedule %q", session.Step, session.Schedule)
+	}
+	sent := tg.sent("sendMessage")
+	if len(sent) != 2 || !strings.Contains(sent[0].Params["text"], "YYYY-MM-DD HH:MM") || !strings.Contains(sent[1].Params["text"], "already passed") {
+		t.Errorf("replies = %+v, want a format hint and a past-time hint", sent)
+	}
+
+	future := time.Now().UTC().Add(time.Hour).Format("2006-01-02 15:04")
+	b.scheduleAt(5, session, future)
+	var sendAt string
+	if err := b.db.QueryRow("SELECT send_at FROM scheduled_emails").Scan(&sendAt); err != nil || sendAt != future {
+		t.Fatalf("scheduled send_at = %q (%v), want %q", sendAt, err, future)
+	}
+}
+
+func TestDueScheduledSkipsUnreadableSendTime(t *testing.T) {
+	b, _ := newTestBot(t)
+	due := time.Now().UTC().Add(-time.Minute)
+	for _, at := range []string{"", "soon", due.Format("2006-01-02 15:04"), due.Format(time.RFC3339)} {
+		if _, err := b.schedulePersist(&EmailSession{ChatID: 5, To: "a@example.com", Subject: at, Schedule: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := b.dueScheduled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("%d jobs due, want the 2 with a readable past send time", len(jobs))
+	}
+	for _, j := range jobs {
+		if j.session.Subject == "" || j.session.Subject == "soon" {
+			t.Errorf("job with send time %q is due", j.session.Subject)
+		}
+	}
+}
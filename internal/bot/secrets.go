@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretPrefix marks values sealed by SecretBox so the format can evolve later
+const secretPrefix = "v1:"
+
+// SecretBox encrypts credentials at rest with AES-256-GCM under a master key
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a SecretBox from a 32 byte master key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts plain and returns "v1:" + base64(nonce || ciphertext)
+func (s *SecretBox) Seal(plain []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := s.aead.Seal(nonce, nonce, plain, nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Open decrypts a value produced by Seal
+func (s *SecretBox) Open(sealed string) ([]byte, error) {
+	if !strings.HasPrefix(sealed, secretPrefix) {
+		return nil, fmt.Errorf("unknown secret format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, secretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("decode secret: %w", err)
+	}
+	n := s.aead.NonceSize()
+	if len(raw) < n {
+		return nil, fmt.Errorf("secret too short")
+	}
+	plain, err := s.aead.Open(nil, raw[:n], raw[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secret (wrong master key?)")
+	}
+	return plain, nil
+}
+
+// LoadMasterKey reads the master key from MASTER_KEY or MASTER_KEY_FILE.
+// It returns nil without error when neither is set.
+func LoadMasterKey() ([]byte, error) {
+	return loadKey("MASTER_KEY", "MASTER_KEY_FILE")
+}
+
+// loadKey reads a key from the env var named by valueVar, or from the file named by fileVar
+func loadKey(valueVar, fileVar string) ([]byte, error) {
+	if v := os.Getenv(valueVar); v != "" {
+		return ParseKey(v)
+	}
+	if path := os.Getenv(fileVar); path != "" {
+		return ReadKeyFile(path)
+	}
+	return nil, nil
+}
+
+// ReadKeyFile reads a base64 or hex encoded key from path
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	return ParseKey(string(data))
+}
+
+// ParseKey decodes a 32 byte key given as base64 or hex
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("master key must be 32 bytes encoded as base64 or hex")
+}
+
+// GenerateKey returns a new random master key encoded as base64
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+
+func TestSecretBoxRoundTrip(t *testing.T) {
+	box, err := NewSecretBox(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte(`{"password":"hunter2"}`)
+	sealed, err := box.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, "v1:") {
+		t.Errorf("sealed value %q lacks the v1: prefix", sealed)
+	}
+	if strings.Contains(sealed, "hunter2") {
+		t.Error("sealed value holds the plaintext")
+	}
+	got, err := box.Open(sealed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Open = %q, %v; want %q", got, err, plain)
+	}
+	// a fresh nonce every time
+	if again, _ := box.Seal(plain); again == sealed {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+}
+
+func TestSecretBoxRejects(t *testing.T) {
+	box, _ := NewSecretBox(testKey(1))
+	sealed, _ := box.Seal([]byte("secret"))
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, "v1:"))
+	raw[len(raw)-1] ^= 1
+	tampered := "v1:" + base64.StdEncoding.EncodeToString(raw)
+	other, _ := NewSecretBox(testKey(2))
+
+	tests := []struct {
+		name   string
+		box    *SecretBox
+		sealed string
+	}{
+		{"tampered ciphertext", box, tampered},
+		{"wrong key", other, sealed},
+		{"missing prefix", box, strings.TrimPrefix(sealed, "v1:")},
+		{"unknown version", box, "v2:" + strings.TrimPrefix(sealed, "v1:")},
+		{"truncated", box, "v1:AAAA"},
+		{"not base64", box, "v1:%%%"},
+	}
+	for _, tt := range tests {
+		if plain, err := tt.box.Open(tt.sealed); err == nil {
+			t.Errorf("%s: Open succeeded with %q", tt.name, plain)
+		}
+	}
+	if _, err := NewSecretBox(testKey(1)[:16]); err == nil {
+		t.Error("NewSecretBox accepted a 16 byte key")
+	}
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	b, _ := newTestBot(t)
+	oldBox, _ := NewSecretBox(testKey(1))
+	newBox, _ := NewSecretBox(testKey(2))
+	b.secrets = oldBox
+	for _, name := range []string{"work", "home", "shop"} {
+		a := &Account{ChatID: 5, Name: name, Host: "smtp.example.com", Port: 587, Username: name + "@example.com", Password: "pw-" + name}
+		if err := b.saveAccount(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := RotateMasterKey(sqliteFile, testKey(1), testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("rotated %d accounts, want 3", n)
+	}
+	rows, err := b.db.Query("SELECT name, secrets_enc FROM smtp_accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	seen := 0
+	for rows.Next() {
+		var name, sealed string
+		if err := rows.Scan(&name, &sealed); err != nil {
+			t.Fatal(err)
+		}
+		seen++
+		if _, err := oldBox.Open(sealed); err == nil {
+			t.Errorf("%s still opens under the old key", name)
+		}
+		plain, err := newBox.Open(sealed)
+		if err != nil {
+			t.Errorf("%s does not open under the new key: %v", name, err)
+		} else if !strings.Contains(string(plain), "pw-"+name) {
+			t.Errorf("%s: secrets after rotation = %s", name, plain)
+		}
+	}
+	if seen != 3 {
+		t.Fatalf("%d account rows, want 3", seen)
+	}
+
+	b.secrets = newBox
+	if a, err := b.accountByName(5, "work"); err != nil || a.Password != "pw-work" {
+		t.Errorf("account after rotation: %v, %v", a, err)
+	}
+	if _, err := RotateMasterKey(sqliteFile, testKey(1), testKey(3)); err == nil {
+		t.Error("rotation with a stale old key succeeded")
+	}
+}
+
+func TestSaveAccountDropsTokenSource(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.secrets, _ = NewSecretBox(testKey(1))
+	a := &Account{ChatID: 5, Name: "work", Host: "smtp.gmail.com", Port: 587, Username: "me@gmail.com",
+		Auth: "xoauth2", ClientID: "id", ClientSecret: "old-secret", RefreshToken: "refresh"}
+	if err := b.saveAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	acct, err := b.accountByName(5, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := b.tokenSource(acct)
+	if b.tokenSource(acct) != ts {
+		t.Fatal("token source not reused for an unchanged account")
+	}
+
+	// /addaccount replaces the account by name, without its id
+	replaced := *a
+	replaced.ID, replaced.ClientSecret = 0, "new-secret"
+	if err := b.saveAccount(&replaced); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.tokens[acct.ID]; ok {
+		t.Fatal("cached token source kept after the account was saved")
+	}
+	acct, _ = b.accountByName(5, "work")
+	if got := b.tokenSource(acct); got == ts || got.ClientSecret != "new-secret" {
+		t.Errorf("token source after save has client secret %q, want a new one with new-secret", got.ClientSecret)
+	}
+
+	// a stale cached source is never handed out, even before a save
+	b.tokens[acct.ID] = ts
+	if got := b.tokenSource(acct); got == ts {
+		t.Error("token source with the old client secret reused")
+	}
+}
@@ -0,0 +1,179 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ---------- /send ----------
+
+const sendUsage = "Usage: /send <to> \"<subject>\" <body> [--cc <addresses>] [--at <YYYY-MM-DD HH:MM | 2h>] [--template <name>]\n" +
+	"Flags go before the body. Separate several addresses with commas. With --template the subject and body are optional."
+
+// sendRequest is a parsed /send command line
+type sendRequest struct {
+	To, Cc, Subject, Body string
+	At, Template          string
+}
+
+var (
+	dateRe      = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	clockTimeRe = regexp.MustCompile(`^\d{1,2}:\d{2}$`)
+)
+
+// sendFlags are the flags /send takes
+var sendFlags = map[string]bool{"--cc": true, "--at": true, "--template": true}
+
+// parseSend reads `<to> "<subject>" <body>` with --cc, --at and --template
+// flags anywhere before the body. The body is the rest of the line as typed.
+// A lone -- ends the flags, and once the body may start anything that is not
+// one of the flags begins it, so a body can start with dashes.
+func parseSend(line string) (*sendRequest, error) {
+	a := &argScanner{s: line}
+	req := &sendRequest{}
+	haveSubject := false
+	endOfFlags := false
+	for {
+		// with a template only a quoted subject overrides the template's
+		atBody := req.To != "" && (haveSubject || req.Template != "" && !a.peekQuoted())
+		if !endOfFlags && a.peekFlag() {
+			flag, _, _, _ := a.next()
+			if flag == "--" {
+				endOfFlags = true
+				continue
+			}
+			if atBody && !sendFlags[flag] {
+				a.unread()
+				req.Body = a.rest()
+				return req, nil
+			}
+			value, _, ok, err := a.next()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s needs a value", flag)
+			}
+			switch flag {
+			case "--cc":
+				req.Cc = value
+			case "--template":
+				req.Template = strings.ToLower(value)
+			case "--at":
+				// accept the date and time as two arguments too
+				if dateRe.MatchString(value) {
+					if clock, _, ok, _ := a.next(); ok && clockTimeRe.MatchString(clock) {
+						value += " " + clock
+					} else if ok {
+						a.unread()
+					}
+				}
+				req.At = value
+			default:
+				return nil, fmt.Errorf("unknown flag %s", flag)
+			}
+			continue
+		}
+		if !atBody {
+			text, _, ok, err := a.next()
+			if err != nil || !ok {
+				return req, err
+			}
+			if req.To == "" {
+				req.To = text
+			} else {
+				req.Subject, haveSubject = text, true
+			}
+			continue
+		}
+		req.Body = a.rest()
+		return req, nil
+	}
+}
+
+// sendTime turns --at into the scheduler's UTC "YYYY-MM-DD HH:MM" form; it
+// takes an absolute time or a delay such as 90m or 2h
+func sendTime(at string, now time.Time) (string, error) {
+	if d, err := time.ParseDuration(at); err == nil {
+		if d <= 0 {
+			return "", fmt.Errorf("--at %s is not in the future", at)
+		}
+		return now.UTC().Add(d).Format("2006-01-02 15:04"), nil
+	}
+	t, err := time.Parse("2006-01-02 15:04", at)
+	if err != nil {
+		return "", fmt.Errorf("--at %q: use YYYY-MM-DD HH:MM (UTC) or a delay like 2h", at)
+	}
+	if !t.After(now) {
+		return "", fmt.Errorf("--at %s UTC has already passed", at)
+	}
+	return at, nil
+}
+
+// cmdSend sends or schedules an email in one command, without the wizard
+func (b *Bot) cmdSend(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	req, err := parseSend(msg.CommandArguments())
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()+"\n\n"+sendUsage))
+		return
+	}
+	if req.Template != "" {
+		t, err := b.templateByName(chatID, req.Template)
+		if err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()))
+			return
+		}
+		req.Subject, req.Body = t.apply(req.Subject, req.Body)
+	}
+	if req.To == "" || req.Subject == "" || req.Body == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, sendUsage))
+		return
+	}
+	to := splitRecipients(req.To)
+	if len(to) == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "⚠️ No recipient: give at least one email address.\n\n"+sendUsage))
+		return
+	}
+	for _, addr := range append(to, splitRecipients(req.Cc)...) {
+		if !strings.Contains(addr, "@") {
+			b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("⚠️ %q is not an email address.\n\n%s", addr, sendUsage)))
+			return
+		}
+	}
+
+	session := &EmailSession{
+		To:        req.To,
+		Cc:        req.Cc,
+		Subject:   req.Subject,
+		Body:      req.Body,
+		ChatID:    chatID,
+		AccountID: b.activeAccountID(chatID),
+		CreatedAt: time.Now().UTC(),
+	}
+	if req.At != "" {
+		if session.Schedule, err = sendTime(req.At, time.Now()); err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()))
+			return
+		}
+		if err := b.checkSessionSize(session); err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "⛔ Not scheduled: "+err.Error()))
+			return
+		}
+		if _, err := b.schedulePersist(session); err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Failed to schedule: "+err.Error()))
+			return
+		}
+		b.API.Send(tgbotapi.NewMessage(chatID, "⏰ Email to "+req.To+" scheduled for "+session.Schedule+" UTC."))
+		return
+	}
+	if err := b.sendMailMulti(b.sendCtx, session); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to send: "+err.Error()))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "✅ Email sent to "+req.To+"!"))
+}
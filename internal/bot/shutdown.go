@@ -0,0 +1,42 @@
+package bot
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// abortGrace is how long aborted deliveries get to unwind before shutdown gives up
+const abortGrace = 5 * time.Second
+
+// Drain waits for the bot's goroutines to finish after their context was
+// cancelled. Deliveries still running after timeout are aborted, which fails
+// their SMTP transactions; scheduled emails hit by that stay pending.
+func (b *Bot) Drain(wait func(), timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+	}
+	log.Printf("shutdown: still busy after %s, aborting in-flight deliveries", timeout)
+	b.abortSends()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(abortGrace):
+		return errors.New("shutdown: some work did not stop, exiting anyway")
+	}
+}
+
+// Close ends pooled SMTP sessions and closes the database
+func (b *Bot) Close() error {
+	b.abortSends()
+	b.smtpPool.Close()
+	return b.db.Close()
+}
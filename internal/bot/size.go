@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shabbirtoha/telegram-mail-bot/internal/mail"
+)
+
+const (
+	// defaultMaxSizeMB is the message size limit when none is configured (Gmail's)
+	defaultMaxSizeMB = 25
+	// sizeWarnPercent is how close to the limit a message gets before the preview warns
+	sizeWarnPercent = 80
+	// headerAllowance covers header fields and MIME boundaries
+	headerAllowance = 4 << 10
+)
+
+// sizeLimit is the largest message the account's provider accepts, in bytes
+func (a *Account) sizeLimit() int64 {
+	mb := a.MaxSizeMB
+	if mb <= 0 {
+		mb = defaultMaxSizeMB
+	}
+	return int64(mb) << 20
+}
+
+// attachmentSize returns the stored size of an attachment
+func (b *Bot) attachmentSize(hash string) int64 {
+	var n int64
+	_ = b.db.QueryRow("SELECT size FROM attachment_blobs WHERE sha256 = ?", hash).Scan(&n)
+	return n
+}
+
+// estimateSize is the size the session's email will have on the wire: the
+// body quoted-printable (twice for HTML, with its text version), attachments
+// base64 encoded, and the armor overhead when it is PGP encrypted
+func (b *Bot) estimateSize(session *EmailSession) int64 {
+	body := int64(len(session.Body)) * 11 / 10
+	if looksLikeHTML(session.Body) {
+		body *= 2
+	}
+	total := int64(headerAllowance) + body
+	for _, h := range sessionHashes(session) {
+		if session.AsLink && h == session.FileHash {
+			continue
+		}
+		total += int64(mail.EncodedSize(int(b.attachmentSize(h))))
+	}
+	if session.Encrypt {
+		total = total * 14 / 10
+	}
+	return total
+}
+
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
+
+// sizeWarning is the preview line (Markdown) about the message size, empty
+// when it is well under the limit
+func (b *Bot) sizeWarning(session *EmailSession) string {
+	acct, err := b.accountByID(session.AccountID)
+	if err != nil {
+		return ""
+	}
+	size, limit := b.estimateSize(session), acct.sizeLimit()
+	switch {
+	case size > limit:
+		// offer only what the preview accepts
+		var options []string
+		if len(sessionHashes(session)) > 0 {
+			options = append(options, "`zip` to compress the attachment")
+		}
+		if session.FileHash != "" && b.linksEnabled() {
+			options = append(options, "`link` to send a download link")
+		}
+		options = append(options, "/cancel")
+		return fmt.Sprintf("⛔ About %s, over the %s limit of %s. Type %s.", formatMB(size), formatMB(limit), tgbotapi.EscapeText(tgbotapi.ModeMarkdown, acct.Name), joinOr(options))
+	case size*100 >= limit*sizeWarnPercent:
+		return fmt.Sprintf("⚠️ About %s, close to the %s limit of %s.", formatMB(size), formatMB(limit), tgbotapi.EscapeText(tgbotapi.ModeMarkdown, acct.Name))
+	}
+	return ""
+}
+
+// checkSize rejects a message its account's provider would refuse anyway
+func (b *Bot) checkSize(acct *Account, session *EmailSession) error {
+	if size, limit := b.estimateSize(session), acct.sizeLimit(); size > limit {
+		return fmt.Errorf("the email is about %s, over the %s limit of %s", formatMB(size), formatMB(limit), acct.Name)
+	}
+	return nil
+}
+
+// checkSessionSize is checkSize for the session's account; an account that
+// fails to load is reported when sending
+func (b *Bot) checkSessionSize(session *EmailSession) error {
+	acct, err := b.accountByID(session.AccountID)
+	if err != nil {
+		return nil
+	}
+	return b.checkSize(acct, session)
+}
+
+// zipAttachment replaces the session's attachment and inline images with
+// one zip archive of them all, then shows the preview with the new size
+func (b *Bot) zipAttachment(chatID int64, session *EmailSession) {
+	type zipEntry struct{ hash, name string }
+	var entries []zipEntry
+	if session.FileHash != "" {
+		entries = append(entries, zipEntry{session.FileHash, session.FileName})
+	}
+	for _, img := range session.Inline {
+		name := img.Name
+		if name == "" {
+			name = img.ContentID
+		}
+		entries = append(entries, zipEntry{img.Hash, name})
+	}
+	switch {
+	case len(entries) == 0:
+		b.API.Send(tgbotapi.NewMessage(chatID, "There is no attachment to zip."))
+		return
+	case len(entries) == 1 && strings.EqualFold(filepath.Ext(entries[0].name), ".zip"):
+		b.API.Send(tgbotapi.NewMessage(chatID, "The attachment already is a zip archive."))
+		return
+	}
+
+	var buf bytes.Buffer
+	var before int64
+	zw := zip.NewWriter(&buf)
+	used := map[string]bool{}
+	for _, e := range entries {
+		data, err := b.readAttachment(e.hash)
+		if err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Failed to read the attachment: "+err.Error()))
+			return
+		}
+		before += int64(len(data))
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: uniqueZipName(e.name, used), Method: zip.Deflate})
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			b.API.Send(tgbotapi.NewMessage(chatID, "Failed to zip the attachment: "+err.Error()))
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to zip the attachment: "+err.Error()))
+		return
+	}
+	hash, err := b.storeAttachment(buf.Bytes())
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to store the archive: "+err.Error()))
+		return
+	}
+	b.retainAttachment(hash, sessionOwner(chatID))
+
+	name := "attachments.zip"
+	if len(entries) == 1 {
+		name = strings.TrimSuffix(entries[0].name, filepath.Ext(entries[0].name)) + ".zip"
+	}
+	text := fmt.Sprintf("🗜 Zipped %d file(s) into %s: %s → %s", len(entries), name, formatMB(before), formatMB(int64(buf.Len())))
+	if len(session.Inline) > 0 {
+		text += "\nInline images are now files in the archive instead of being shown in the HTML."
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, text))
+	session.FileHash, session.FileName, session.FileType = hash, name, "application/zip"
+	session.Inline = nil
+	// the preview repeats the size check against the archive
+	b.sendPreview(chatID, session)
+}
+
+// uniqueZipName returns name, numbered when an earlier entry already took it
+func uniqueZipName(name string, used map[string]bool) string {
+	if name == "" {
+		name = "file"
+	}
+	unique := name
+	ext := filepath.Ext(name)
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[unique] = true
+	return unique
+}
+
+// joinOr lists options as "a, b, or c"
+func joinOr(options []string) string {
+	if len(options) < 3 {
+		return strings.Join(options, " or ")
+	}
+	return strings.Join(options[:len(options)-1], ", ") + ", or " + options[len(options)-1]
+}
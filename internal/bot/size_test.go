@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestZipAttachmentTakesInlineImages(t *testing.T) {
+	b, _ := newTestBot(t)
+	store := func(data string) string {
+		t.Helper()
+		h, err := b.storeAttachment([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.retainAttachment(h, sessionOwner(5))
+		return h
+	}
+	files := map[string]string{
+		"report.txt":    strings.Repeat("quarterly numbers ", 4000),
+		"chart.png":     strings.Repeat("chart ", 4000),
+		"chart (2).png": strings.Repeat("other chart ", 4000),
+	}
+	session := &EmailSession{
+		ChatID: 5, To: "a@example.com", Subject: "Report", Body: `<p>See <img src="cid:image1"></p>`,
+		FileHash: store(files["report.txt"]), FileName: "report.txt", FileType: "text/plain",
+		Inline: []inlineImage{
+			{ContentID: "image1", Hash: store(files["chart.png"]), Name: "chart.png", Type: "image/png"},
+			{ContentID: "image2", Hash: store(files["chart (2).png"]), Name: "chart.png", Type: "image/png"},
+		},
+	}
+	before := b.estimateSize(session)
+	b.zipAttachment(5, session)
+
+	if session.FileName != "attachments.zip" || session.FileType != "application/zip" || len(session.Inline) != 0 {
+		t.Fatalf("session after zip: file %q (%s), %d inline", session.FileName, session.FileType, len(session.Inline))
+	}
+	if after := b.estimateSize(session); after >= before {
+		t.Errorf("estimated size %d after zipping, was %d", after, before)
+	}
+	data, err := b.readAttachment(session.FileHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(content)
+	}
+	if !reflect.DeepEqual(got, files) {
+		names := make([]string, 0, len(got))
+		for n := range got {
+			names = append(names, n)
+		}
+		t.Errorf("archive holds %q, want report.txt, chart.png and chart (2).png", names)
+	}
+}
+
+func TestSizeWarningOffersLinkOnlyWhenEnabled(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.defaultAccount.MaxSizeMB = 1
+	h, err := b.storeAttachment(bytes.Repeat([]byte{0xAB}, 2<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &EmailSession{ChatID: 5, To: "a@example.com", Subject: "Big", Body: "see attached",
+		FileHash: h, FileName: "big.bin", FileType: "application/octet-stream"}
+
+	warning := b.sizeWarning(session)
+	if !strings.Contains(warning, "`zip`") || strings.Contains(warning, "`link`") {
+		t.Errorf("links disabled: warning = %q, want zip but no link", warning)
+	}
+
+	b.httpAddr, b.publicURL = "127.0.0.1:0", "https://bot.example.com"
+	if warning := b.sizeWarning(session); !strings.Contains(warning, "`link`") {
+		t.Errorf("links enabled: warning = %q, want a link suggestion", warning)
+	}
+}
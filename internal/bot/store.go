@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	// defaultAttachmentRetention is how long an unreferenced attachment is kept
+	defaultAttachmentRetention = 24 * time.Hour
+	// janitorInterval is how often unreferenced attachments are looked for
+	janitorInterval = time.Hour
+)
+
+// Attachments are stored once per content under attachments/<sha256[:2]>/<sha256>.
+// The names users gave them only live in session and job metadata, so uploads
+// can neither collide nor escape the directory. Every session, draft or
+// scheduled job using a file holds a reference in attachment_refs; the janitor
+// deletes files that have had no reference for the retention period.
+
+var sha256Re = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// attachmentPath returns where the content with the given hash is stored
+func attachmentPath(hash string) (string, error) {
+	if !sha256Re.MatchString(hash) {
+		return "", fmt.Errorf("invalid attachment hash %q", hash)
+	}
+	return filepath.Join(attachmentsDir, hash[:2], hash), nil
+}
+
+func sessionOwner(chatID int64) string { return fmt.Sprintf("session:%d", chatID) }
+func scheduledOwner(id int64) string   { return fmt.Sprintf("scheduled:%d", id) }
+func draftOwner(id int64) string       { return fmt.Sprintf("draft:%d", id) }
+
+// storeAttachment saves data under its SHA-256 and returns the hash
+func (b *Bot) storeAttachment(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path, _ := attachmentPath(hash)
+
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	if _, err := os.Stat(path); err != nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+		if err != nil {
+			return "", err
+		}
+		_, err = tmp.Write(data)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return "", fmt.Errorf("store attachment: %w", err)
+		}
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := b.db.Exec(`INSERT INTO attachment_blobs (sha256, size, created_at, touched_at) VALUES (?, ?, ?, ?)
+	ON CONFLICT(sha256) DO UPDATE SET touched_at = excluded.touched_at`, hash, len(data), now, now)
+	return hash, err
+}
+
+// readAttachment loads stored content by hash
+func (b *Bot) readAttachment(hash string) ([]byte, error) {
+	path, err := attachmentPath(hash)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// retainAttachment records that owner uses the content
+func (b *Bot) retainAttachment(hash, owner string) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	if _, err := b.db.Exec("INSERT OR IGNORE INTO attachment_refs (sha256, owner) VALUES (?, ?)", hash, owner); err != nil {
+		log.Printf("retain attachment %s for %s: %v", hash, owner, err)
+	}
+}
+
+// releaseAttachments drops every reference held by owner
+func (b *Bot) releaseAttachments(owner string) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := b.db.Exec(`UPDATE attachment_blobs SET touched_at = ?
+	WHERE sha256 IN (SELECT sha256 FROM attachment_refs WHERE owner = ?)`, now, owner)
+	if err == nil {
+		_, err = b.db.Exec("DELETE FROM attachment_refs WHERE owner = ?", owner)
+	}
+	if err != nil {
+		log.Printf("release attachments of %s: %v", owner, err)
+	}
+}
+
+// sessionHashes lists the stored content a compose session uses
+func sessionHashes(s *EmailSession) []string {
+	var out []string
+	if s.FileHash != "" {
+		out = append(out, s.FileHash)
+	}
+	for _, img := range s.Inline {
+		out = append(out, img.Hash)
+	}
+	return out
+}
+
+// StartAttachmentJanitor periodically deletes attachments nothing has referenced
+// for the retention period, until ctx is done
+func (b *Bot) StartAttachmentJanitor(ctx context.Context) {
+	for {
+		b.expireLinks()
+		if n, err := b.cleanAttachments(); err != nil {
+			log.Println("attachment janitor:", err)
+		} else if n > 0 {
+			log.Printf("attachment janitor: removed %d unreferenced file(s)", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(janitorInterval):
+		}
+	}
+}
+
+func (b *Bot) cleanAttachments() (int, error) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	cutoff := time.Now().Add(-b.attachmentRetention)
+
+	rows, err := b.db.Query(`SELECT sha256 FROM attachment_blobs a WHERE touched_at < ?
+	AND NOT EXISTS (SELECT 1 FROM attachment_refs r WHERE r.sha256 = a.sha256)`, cutoff.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if rows.Scan(&h) == nil {
+			hashes = append(hashes, h)
+		}
+	}
+	rows.Close()
+
+	removed := 0
+	for _, h := range hashes {
+		if path, err := attachmentPath(h); err == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("attachment janitor: %v", err)
+				continue
+			}
+		}
+		if _, err := b.db.Exec("DELETE FROM attachment_blobs WHERE sha256 = ?", h); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	// loose files from before content addressing, named after the upload
+	entries, _ := os.ReadDir(attachmentsDir)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if os.Remove(filepath.Join(attachmentsDir, e.Name())) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// prepareAttachmentStore runs at startup: in-memory sessions did not survive the
+// restart so their references are dropped, and pending scheduled emails that
+// still point at a loose file are moved into the store
+func (b *Bot) prepareAttachmentStore() error {
+	if _, err := b.db.Exec("DELETE FROM attachment_refs WHERE owner LIKE 'session:%'"); err != nil {
+		return err
+	}
+
+	rows, err := b.db.Query("SELECT id, attachments_json FROM scheduled_emails WHERE status = 'pending' AND attachments_json LIKE '%\"path\"%'")
+	if err != nil {
+		return err
+	}
+	type legacy struct {
+		id   int64
+		list []map[string]string
+	}
+	var todo []legacy
+	for rows.Next() {
+		var l legacy
+		var attJSON string
+		if rows.Scan(&l.id, &attJSON) == nil && json.Unmarshal([]byte(attJSON), &l.list) == nil {
+			todo = append(todo, l)
+		}
+	}
+	rows.Close()
+
+	for _, l := range todo {
+		for _, a := range l.list {
+			if a["path"] == "" {
+				continue
+			}
+			data, err := os.ReadFile(a["path"])
+			if err != nil {
+				log.Printf("scheduled email %d: attachment %s: %v", l.id, a["path"], err)
+				continue
+			}
+			hash, err := b.storeAttachment(data)
+			if err != nil {
+				return err
+			}
+			b.retainAttachment(hash, scheduledOwner(l.id))
+			a["sha256"] = hash
+			delete(a, "path")
+		}
+		j, _ := json.Marshal(l.list)
+		if _, err := b.db.Exec("UPDATE scheduled_emails SET attachments_json = ? WHERE id = ?", string(j), l.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// bodyPlaceholder in a template body is replaced by the body given to /send
+const bodyPlaceholder = "{{body}}"
+
+// mailTemplate is a saved subject and body for /send --template
+type mailTemplate struct {
+	Name    string
+	Subject string
+	Body    string
+}
+
+// apply fills in the template: a given subject replaces the template's, a
+// given body goes where {{body}} is or replaces the template's body
+func (t *mailTemplate) apply(subject, body string) (string, string) {
+	if subject == "" {
+		subject = t.Subject
+	}
+	switch {
+	case strings.Contains(t.Body, bodyPlaceholder):
+		body = strings.ReplaceAll(t.Body, bodyPlaceholder, body)
+	case body == "":
+		body = t.Body
+	}
+	return subject, body
+}
+
+func (b *Bot) templateByName(chatID int64, name string) (*mailTemplate, error) {
+	t := mailTemplate{Name: name}
+	err := b.db.QueryRow("SELECT subject, body FROM templates WHERE chat_id = ? AND name = ?", chatID, name).Scan(&t.Subject, &t.Body)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no template named %q, see /templates", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// cmdAddTemplate handles /addtemplate <name> "<subject>" <body>
+func (b *Bot) cmdAddTemplate(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	args, body, err := splitLeadingArgs(msg.CommandArguments(), 2)
+	if err != nil || len(args) < 2 || body == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /addtemplate <name> \"<subject>\" <body>\nPut {{body}} in the body where the text given to /send should go."))
+		return
+	}
+	name, subject := strings.ToLower(args[0]), args[1]
+	b.dbMu.Lock()
+	_, err = b.db.Exec(`INSERT INTO templates (chat_id, name, subject, body, created_at) VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(chat_id, name) DO UPDATE SET subject = excluded.subject, body = excluded.body`,
+		chatID, name, subject, body, time.Now().UTC().Format(time.RFC3339))
+	b.dbMu.Unlock()
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to save template: "+err.Error()))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Template %s saved. Use it with /send <to> --template %s", name, name)))
+}
+
+func (b *Bot) cmdListTemplates(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	rows, err := b.db.Query("SELECT name, subject FROM templates WHERE chat_id = ? ORDER BY name", chatID)
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to query templates: "+err.Error()))
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var name, subject string
+		_ = rows.Scan(&name, &subject)
+		lines = append(lines, fmt.Sprintf("%s — %s", name, subject))
+	}
+	if len(lines) == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No templates yet. Add one with /addtemplate <name> \"<subject>\" <body>"))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "📄 Templates\n"+strings.Join(lines, "\n")))
+}
+
+func (b *Bot) cmdDeleteTemplate(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	name := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	if name == "" {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Usage: /deltemplate <name>"))
+		return
+	}
+	b.dbMu.Lock()
+	res, err := b.db.Exec("DELETE FROM templates WHERE chat_id = ? AND name = ?", chatID, name)
+	b.dbMu.Unlock()
+	if err != nil {
+		b.API.Send(tgbotapi.NewMessage(chatID, "Failed to delete template: "+err.Error()))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		b.API.Send(tgbotapi.NewMessage(chatID, "No template named "+name+"."))
+		return
+	}
+	b.API.Send(tgbotapi.NewMessage(chatID, "🗑 Template "+name+" deleted."))
+}
@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// defaultWebhookPath is where Telegram posts updates when WEBHOOK_URL has no path
+	defaultWebhookPath = "/telegram/webhook"
+	// webhookSecretHeader carries the secret_token given to setWebhook
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// maxWebhookBody bounds one update; Telegram's are a few KB
+	maxWebhookBody = 1 << 20
+)
+
+// webhookSecretRe is the character set Telegram allows in a secret token
+var webhookSecretRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookConfig resolves the webhook settings: the URL Telegram posts to
+// (WEBHOOK_URL, or PUBLIC_URL plus the default path) and the secret token
+// (WEBHOOK_SECRET, or a random one for this run)
+func webhookConfig(rawURL, publicURL, secret string) (*url.URL, string, error) {
+	if rawURL == "" {
+		if publicURL == "" {
+			return nil, "", fmt.Errorf("BOT_MODE=webhook needs WEBHOOK_URL or PUBLIC_URL")
+		}
+		rawURL = publicURL + defaultWebhookPath
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, "", fmt.Errorf("WEBHOOK_URL %q: Telegram only posts to https:// URLs", rawURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultWebhookPath
+	}
+	if secret == "" {
+		var raw [32]byte
+		if _, err := rand.Read(raw[:]); err != nil {
+			return nil, "", err
+		}
+		secret = base64.RawURLEncoding.EncodeToString(raw[:])
+	} else if !webhookSecretRe.MatchString(secret) {
+		return nil, "", fmt.Errorf("WEBHOOK_SECRET may only hold A-Z, a-z, 0-9, _ and - (up to 256 characters)")
+	}
+	return u, secret, nil
+}
+
+// updates returns the channel Start reads updates from. In webhook mode it
+// registers the webhook; when that fails the bot falls back to long polling.
+func (b *Bot) updates() <-chan tgbotapi.Update {
+	if b.webhookURL != nil {
+		err := b.startWebhook()
+		if err == nil {
+			log.Printf("receiving updates by webhook at %s", b.webhookURL.Redacted())
+			return b.webhookUpdates
+		}
+		log.Printf("webhook: %v; falling back to long polling", err)
+	}
+
+	// getUpdates is refused while a webhook is set, e.g. by an earlier run
+	if _, err := b.API.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Println("delete webhook:", err)
+	}
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	log.Println("receiving updates by long polling")
+	return b.API.GetUpdatesChan(u)
+}
+
+// startWebhook serves the webhook path and tells Telegram to post there
+func (b *Bot) startWebhook() error {
+	if b.httpAddr == "" {
+		return fmt.Errorf("webhook mode needs HTTP_ADDR for the bot to listen on")
+	}
+	b.mux.HandleFunc(b.webhookURL.Path, b.serveWebhook)
+
+	allowed, _ := json.Marshal([]string{"message", "callback_query"})
+	_, err := b.API.MakeRequest("setWebhook", tgbotapi.Params{
+		"url":             b.webhookURL.String(),
+		"secret_token":    b.webhookSecret,
+		"allowed_updates": string(allowed),
+	})
+	if err != nil {
+		return fmt.Errorf("setWebhook: %w", err)
+	}
+	return nil
+}
+
+// serveWebhook accepts an update posted by Telegram
+func (b *Bot) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretHeader)), []byte(b.webhookSecret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&update); err != nil {
+		http.Error(w, "bad update", http.StatusBadRequest)
+		return
+	}
+	// blocks while the dispatcher is behind; Telegram then waits and retries
+	select {
+	case b.webhookUpdates <- update:
+	case <-r.Context().Done():
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebhookSecretToken(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.httpAddr = "127.0.0.1:0"
+	b.webhookURL, _ = url.Parse("https://bot.example.com" + defaultWebhookPath)
+	b.webhookSecret = "s3cret-token"
+	if err := b.startWebhook(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := tg.sent("setWebhook"); len(calls) != 1 || calls[0].Params["secret_token"] != b.webhookSecret {
+		t.Fatalf("setWebhook calls = %+v, want one carrying the secret", calls)
+	}
+	srv := httptest.NewServer(b.mux)
+	defer srv.Close()
+
+	const update = `{"update_id":42,"message":{"message_id":1,"date":0,"chat":{"id":7,"type":"private"},"text":"/start"}}`
+	post := func(secret string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+defaultWebhookPath, strings.NewReader(update))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set(webhookSecretHeader, secret)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for name, secret := range map[string]string{"missing": "", "wrong": "s3cret-tokem"} {
+		if code := post(secret); code != http.StatusForbidden {
+			t.Errorf("%s secret: status %d, want 403", name, code)
+		}
+	}
+	if len(b.webhookUpdates) != 0 {
+		t.Fatal("a rejected update reached the dispatcher")
+	}
+
+	if code := post(b.webhookSecret); code != http.StatusOK {
+		t.Fatalf("valid secret: status %d, want 200", code)
+	}
+	select {
+	case u := <-b.webhookUpdates:
+		if u.UpdateID != 42 || u.Message == nil || u.Message.Chat.ID != 7 || u.Message.Text != "/start" {
+			t.Errorf("update = %+v, want update 42 from chat 7", u)
+		}
+	default:
+		t.Fatal("accepted update did not reach the dispatcher")
+	}
+
+	resp, err := srv.Client().Get(srv.URL + defaultWebhookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", resp.StatusCode)
+	}
+}
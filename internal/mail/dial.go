@@ -0,0 +1,193 @@
+package mail
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLSMode selects how the SMTP connection is secured
+type TLSMode string
+
+const (
+	// TLSImplicit connects with TLS from the first byte (SMTPS, usually port 465)
+	TLSImplicit TLSMode = "implicit"
+	// TLSStartTLS requires the server to offer STARTTLS and upgrades before authenticating
+	TLSStartTLS TLSMode = "starttls"
+	// TLSOpportunistic upgrades with STARTTLS when offered, like smtp.SendMail
+	TLSOpportunistic TLSMode = "opportunistic"
+	// TLSNone never uses TLS, for local relays
+	TLSNone TLSMode = "none"
+)
+
+// defaultDialTimeout bounds connecting, the TLS handshake and the SMTP greeting
+const defaultDialTimeout = 30 * time.Second
+
+// ParseTLSMode validates a TLS mode name. An empty name selects the default for the port.
+func ParseTLSMode(s string) (TLSMode, error) {
+	switch m := TLSMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "", TLSImplicit, TLSStartTLS, TLSOpportunistic, TLSNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("tls must be implicit, starttls, opportunistic or none")
+}
+
+// DialConfig describes how to reach an SMTP server
+type DialConfig struct {
+	Host string
+	Port int
+	Mode TLSMode
+	// ServerName overrides the name the certificate is verified against
+	ServerName string
+	// CAFile is a PEM bundle trusted in addition to the system roots; CAPEM
+	// is the same for a bundle held in memory
+	CAFile  string
+	CAPEM   []byte
+	Timeout time.Duration
+}
+
+// mode returns the configured mode, defaulting to implicit TLS on 465 and opportunistic STARTTLS elsewhere
+func (cfg DialConfig) mode() TLSMode {
+	if cfg.Mode != "" {
+		return cfg.Mode
+	}
+	if cfg.Port == 465 {
+		return TLSImplicit
+	}
+	return TLSOpportunistic
+}
+
+func (cfg DialConfig) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName: cfg.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.ServerName != "" {
+		tc.ServerName = cfg.ServerName
+	}
+	pem, source := cfg.CAPEM, "uploaded CA bundle"
+	if cfg.CAFile != "" {
+		var err error
+		if pem, err = os.ReadFile(cfg.CAFile); err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		source = "CA bundle " + cfg.CAFile
+	}
+	if len(pem) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", source)
+		}
+		tc.RootCAs = pool
+	}
+	return tc, nil
+}
+
+// Dial connects to the SMTP server and secures the connection according to
+// cfg.Mode. The returned client has completed EHLO (and STARTTLS when used)
+// but is not yet authenticated.
+func Dial(cfg DialConfig) (*smtp.Client, error) {
+	mode := cfg.mode()
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	tc, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	if mode == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tc)
+		if err != nil {
+			return nil, fmt.Errorf("implicit TLS to %s failed (if the server expects STARTTLS, use tls=starttls): %w", addr, err)
+		}
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("connect to %s: %w", addr, err)
+		}
+	}
+
+	// a server speaking TLS on a plain connection never sends a greeting,
+	// so bound the setup phase instead of hanging forever
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		if mode != TLSImplicit && isTimeout(err) {
+			return nil, fmt.Errorf("no SMTP greeting from %s (if the server expects implicit TLS, use tls=implicit): %w", addr, err)
+		}
+		return nil, fmt.Errorf("smtp greeting from %s: %w", addr, err)
+	}
+	if err := c.Hello("localhost"); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("EHLO %s: %w", addr, err)
+	}
+
+	if mode == TLSStartTLS || mode == TLSOpportunistic {
+		ok, _ := c.Extension("STARTTLS")
+		if !ok && mode == TLSStartTLS {
+			c.Close()
+			return nil, fmt.Errorf("server %s does not offer STARTTLS but tls=starttls requires it (use tls=implicit for SMTPS or tls=none for a local relay)", addr)
+		}
+		if ok {
+			if err := c.StartTLS(tc); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("STARTTLS with %s: %w", addr, err)
+			}
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// authenticate runs AUTH when auth is set
+func authenticate(c *smtp.Client, auth smtp.Auth) error {
+	if auth == nil {
+		return nil
+	}
+	if ok, _ := c.Extension("AUTH"); !ok {
+		return errors.New("smtp: server doesn't support AUTH")
+	}
+	return c.Auth(auth)
+}
+
+// deliver runs one MAIL/RCPT/DATA transaction
+func deliver(c *smtp.Client, from string, to []string, msg []byte) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,241 @@
+package mail
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway CA and a server certificate it issued for 127.0.0.1
+type testCA struct {
+	pem    []byte
+	server tls.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		pem:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		server: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+// fakeSMTP is a minimal SMTP server: it greets, answers EHLO (offering
+// STARTTLS when starttls is set), upgrades on STARTTLS and accepts the rest
+type fakeSMTP struct {
+	implicit bool
+	starttls bool
+	cert     tls.Certificate
+}
+
+func (f *fakeSMTP) start(t *testing.T) (host string, port int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	tc := &tls.Config{Certificates: []tls.Certificate{f.cert}}
+	if f.implicit {
+		conn = tls.Server(conn, tc)
+	}
+	defer func() { conn.Close() }()
+	_, secure := conn.(*tls.Conn)
+	r := bufio.NewReader(conn)
+	write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	write("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch verb := strings.ToUpper(strings.Fields(line + " x")[0]); verb {
+		case "EHLO", "HELO":
+			if f.starttls && !secure {
+				write("250-fake")
+				write("250 STARTTLS")
+			} else {
+				write("250 fake")
+			}
+		case "STARTTLS":
+			write("220 ready")
+			tlsConn := tls.Server(conn, tc)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			r = bufio.NewReader(conn)
+		case "QUIT":
+			write("221 bye")
+			return
+		default:
+			write("250 ok")
+		}
+	}
+}
+
+func TestDialTLSModes(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		server     fakeSMTP
+		cfg        DialConfig
+		wantTLS    bool
+		wantErrSub string
+	}{
+		{
+			name:    "implicit TLS with CA file",
+			server:  fakeSMTP{implicit: true},
+			cfg:     DialConfig{Mode: TLSImplicit, CAFile: caFile},
+			wantTLS: true,
+		},
+		{
+			name:    "implicit TLS with uploaded CA",
+			server:  fakeSMTP{implicit: true},
+			cfg:     DialConfig{Mode: TLSImplicit, CAPEM: ca.pem},
+			wantTLS: true,
+		},
+		{
+			name:       "implicit TLS without the custom CA",
+			server:     fakeSMTP{implicit: true},
+			cfg:        DialConfig{Mode: TLSImplicit},
+			wantErrSub: "certificate",
+		},
+		{
+			name:    "required STARTTLS",
+			server:  fakeSMTP{starttls: true},
+			cfg:     DialConfig{Mode: TLSStartTLS, CAFile: caFile},
+			wantTLS: true,
+		},
+		{
+			name:       "required STARTTLS not offered",
+			server:     fakeSMTP{},
+			cfg:        DialConfig{Mode: TLSStartTLS, CAFile: caFile},
+			wantErrSub: "does not offer STARTTLS",
+		},
+		{
+			name:       "STARTTLS without the custom CA",
+			server:     fakeSMTP{starttls: true},
+			cfg:        DialConfig{Mode: TLSStartTLS},
+			wantErrSub: "certificate",
+		},
+		{
+			name:    "opportunistic upgrades when offered",
+			server:  fakeSMTP{starttls: true},
+			cfg:     DialConfig{Mode: TLSOpportunistic, CAFile: caFile},
+			wantTLS: true,
+		},
+		{
+			name:   "opportunistic stays plain when not offered",
+			server: fakeSMTP{},
+			cfg:    DialConfig{Mode: TLSOpportunistic},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.server.cert = ca.server
+			tt.cfg.Host, tt.cfg.Port = tt.server.start(t)
+			tt.cfg.Timeout = 5 * time.Second
+
+			c, err := Dial(tt.cfg)
+			if tt.wantErrSub != "" {
+				if err == nil {
+					c.Close()
+					t.Fatalf("Dial succeeded, want an error containing %q", tt.wantErrSub)
+				}
+				if !strings.Contains(err.Error(), tt.wantErrSub) {
+					t.Fatalf("Dial error = %v, want it to contain %q", err, tt.wantErrSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if _, ok := c.TLSConnectionState(); ok != tt.wantTLS {
+				t.Errorf("TLS = %v, want %v", ok, tt.wantTLS)
+			}
+			if err := c.Quit(); err != nil {
+				t.Errorf("QUIT: %v", err)
+			}
+		})
+	}
+}
+
+func TestDialImplicitAgainstPlainServer(t *testing.T) {
+	// a STARTTLS server answers the TLS hello with a plain greeting
+	srv := fakeSMTP{starttls: true}
+	host, port := srv.start(t)
+	_, err := Dial(DialConfig{Host: host, Port: port, Mode: TLSImplicit, Timeout: time.Second})
+	if err == nil || !strings.Contains(err.Error(), "use tls=starttls") {
+		t.Fatalf("Dial error = %v, want a hint to use tls=starttls", err)
+	}
+}
@@ -0,0 +1,188 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are signed when present in the message
+var dkimHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// DKIMSigner adds a DKIM-Signature header (relaxed/relaxed canonicalization)
+// using rsa-sha256 or ed25519-sha256 depending on the key type
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+}
+
+// LoadDKIMKey reads a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key
+func LoadDKIMKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read DKIM key: %w", err)
+	}
+	return ParseDKIMKey(data)
+}
+
+// ParseDKIMKey is LoadDKIMKey for a key already in memory
+func ParseDKIMKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("DKIM key: no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("DKIM key: %w", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("DKIM key: unsupported key type %T", key)
+}
+
+func (d *DKIMSigner) algorithm() (string, error) {
+	switch d.Key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	}
+	return "", fmt.Errorf("DKIM: unsupported key type %T", d.Key)
+}
+
+// Sign returns msg with a DKIM-Signature header prepended. msg must use CRLF line endings.
+func (d *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	algo, err := d.algorithm()
+	if err != nil {
+		return nil, err
+	}
+	headerEnd := bytes.Index(msg, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		return nil, errors.New("DKIM: message has no header/body separator")
+	}
+	headers := splitHeaders(string(msg[:headerEnd+2]))
+	body := msg[headerEnd+4:]
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var names []string
+	var canon strings.Builder
+	for _, name := range dkimHeaders {
+		if h, ok := lastHeader(headers, name); ok {
+			names = append(names, strings.ToLower(name))
+			canon.WriteString(relaxedHeader(h))
+		}
+	}
+
+	sig := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n t=%d; h=%s;\r\n bh=%s;\r\n b=",
+		algo, d.Domain, d.Selector, time.Now().Unix(), strings.Join(names, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	// the signature header itself is hashed with an empty b= and without its trailing CRLF
+	canon.WriteString(strings.TrimSuffix(relaxedHeader(sig+"\r\n"), "\r\n"))
+	digest := sha256.Sum256([]byte(canon.String()))
+
+	var signature []byte
+	if _, ok := d.Key.(ed25519.PrivateKey); ok {
+		// RFC 8463: Ed25519 signs the SHA-256 hash of the canonicalized data
+		signature, err = d.Key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	} else {
+		signature, err = d.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DKIM sign: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sig)
+	out.WriteString(foldBase64(base64.StdEncoding.EncodeToString(signature)))
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// splitHeaders splits a header block into fields, keeping folded lines together
+func splitHeaders(block string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(block, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// lastHeader returns the bottom-most field with the given name
+func lastHeader(fields []string, name string) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		k, _, ok := strings.Cut(fields[i], ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return fields[i], true
+		}
+	}
+	return "", false
+}
+
+var wspRun = regexp.MustCompile(`[ \t]+`)
+
+// relaxedHeader applies the relaxed header canonicalization of RFC 6376 3.4.2
+func relaxedHeader(field string) string {
+	k, v, _ := strings.Cut(field, ":")
+	v = strings.ReplaceAll(v, "\r\n", "")
+	v = wspRun.ReplaceAllString(v, " ")
+	return strings.ToLower(strings.TrimSpace(k)) + ":" + strings.TrimSpace(v) + "\r\n"
+}
+
+// relaxedBody applies the relaxed body canonicalization of RFC 6376 3.4.4
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(wspRun.ReplaceAllString(l, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// foldBase64 breaks a long base64 value into folded header lines
+func foldBase64(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i += 72 {
+		if i > 0 {
+			b.WriteString("\r\n ")
+		}
+		b.WriteString(s[i:min(i+72, len(s))])
+	}
+	return b.String()
+}
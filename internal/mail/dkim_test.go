@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimTestMessage has the untidy whitespace relaxed canonicalization must undo
+const dkimTestMessage = "From: Bot <bot@example.com>\r\n" +
+	"To: alice@example.com\r\n" +
+	"Subject:   Quarterly\t report\r\n" +
+	"  continued\r\n" +
+	"Date: Mon, 05 Jan 2026 10:00:00 +0000\r\n" +
+	"Message-ID: <1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Hello  Alice, \r\n" +
+	"\t numbers attached.\t\r\n" +
+	"\r\n" +
+	"\r\n"
+
+// the relaxed body of dkimTestMessage: runs of whitespace folded, trailing
+// whitespace and empty lines removed
+const dkimTestBody = "Hello Alice,\r\n numbers attached.\r\n"
+
+func dkimTXT(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k)
+	}
+	t.Fatalf("unexpected key type %T", key)
+	return ""
+}
+
+// verifyDKIM checks signed with go-msgauth, serving txt for the selector record
+func verifyDKIM(t *testing.T, signed []byte, txt string) *dkim.Verification {
+	t.Helper()
+	verifs, err := dkim.VerifyWithOptions(bytes.NewReader(signed), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "mail._domainkey.example.com" {
+				t.Errorf("TXT lookup for %q", domain)
+			}
+			return []string{txt}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifs) != 1 {
+		t.Fatalf("%d signatures found, want 1", len(verifs))
+	}
+	return verifs[0]
+}
+
+func TestDKIMSignVerifies(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBH := sha256.Sum256([]byte(dkimTestBody))
+	tagRe := regexp.MustCompile(`(?:^|[;\s])(a|c|bh)=([^;]+);`)
+
+	for _, tt := range []struct {
+		algo string
+		key  crypto.Signer
+	}{
+		{"rsa-sha256", rsaKey},
+		{"ed25519-sha256", edKey},
+	} {
+		t.Run(tt.algo, func(t *testing.T) {
+			d := &DKIMSigner{Domain: "example.com", Selector: "mail", Key: tt.key}
+			signed, err := d.Sign([]byte(dkimTestMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			txt := dkimTXT(t, tt.key)
+			if v := verifyDKIM(t, signed, txt); v.Err != nil || v.Domain != "example.com" {
+				t.Fatalf("verification: domain %q, %v", v.Domain, v.Err)
+			}
+
+			header, _, _ := strings.Cut(string(signed), "\r\nFrom:")
+			tags := map[string]string{}
+			for _, m := range tagRe.FindAllStringSubmatch(strings.ReplaceAll(header, "\r\n", ""), -1) {
+				tags[m[1]] = strings.TrimSpace(m[2])
+			}
+			if tags["a"] != tt.algo || tags["c"] != "relaxed/relaxed" {
+				t.Errorf("a=%q c=%q, want %s relaxed/relaxed", tags["a"], tags["c"], tt.algo)
+			}
+			if want := base64.StdEncoding.EncodeToString(wantBH[:]); tags["bh"] != want {
+				t.Errorf("bh=%s, want the hash of the relaxed body %s", tags["bh"], want)
+			}
+
+			// whitespace and header name case changes in transit survive
+			// relaxed/relaxed canonicalization
+			rewritten := strings.NewReplacer(
+				"Subject:   Quarterly\t report\r\n  continued", "subject: Quarterly report continued",
+				"Hello  Alice, \r\n", "Hello Alice,\r\n",
+				"attached.\t\r\n\r\n\r\n", "attached.\r\n",
+			).Replace(string(signed))
+			if rewritten == string(signed) {
+				t.Fatal("rewrite did not change the message")
+			}
+			if v := verifyDKIM(t, []byte(rewritten), txt); v.Err != nil {
+				t.Errorf("whitespace-only changes broke the signature: %v", v.Err)
+			}
+
+			for name, altered := range map[string]string{
+				"body":    strings.Replace(string(signed), "Alice,", "Mallory,", 1),
+				"subject": strings.Replace(string(signed), "Quarterly", "Annual", 1),
+			} {
+				if v := verifyDKIM(t, []byte(altered), txt); v.Err == nil {
+					t.Errorf("signature verifies over an altered %s", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	_ "github.com/emersion/go-message/charset" // decode non UTF-8 mail
+	gomail "github.com/emersion/go-message/mail"
+)
+
+// maxInboundText bounds the text kept from an incoming message
+const maxInboundText = 64 << 10
+
+// maxInboundAttachment is Telegram's upload limit for bots. One byte more is
+// read so a cut attachment can be told apart from one that just fits.
+const maxInboundAttachment = 50 << 20
+
+// InboundMessage is an email fetched from an IMAP mailbox
+type InboundMessage struct {
+	UID         uint32
+	MessageID   string
+	References  []string
+	From        string
+	FromName    string
+	ReplyTo     string
+	Subject     string
+	Date        time.Time
+	Text        string
+	Attachments []Attachment
+}
+
+// DialIMAP connects to an IMAP server using the TLS mode in cfg (implicit TLS
+// by default on 993) and authenticates with login, which receives the
+// connected client.
+func DialIMAP(cfg DialConfig, login func(*client.Client) error) (*client.Client, error) {
+	mode := cfg.Mode
+	if mode == "" {
+		if cfg.Port == 993 {
+			mode = TLSImplicit
+		} else {
+			mode = TLSStartTLS
+		}
+	}
+	tc, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var c *client.Client
+	if mode == TLSImplicit {
+		c, err = client.DialWithDialerTLS(dialer, addr, tc)
+	} else {
+		c, err = client.DialWithDialer(dialer, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("imap connect to %s: %w", addr, err)
+	}
+	c.Timeout = timeout
+
+	if mode == TLSStartTLS || mode == TLSOpportunistic {
+		ok, _ := c.SupportStartTLS()
+		if !ok && mode == TLSStartTLS {
+			c.Logout()
+			return nil, fmt.Errorf("imap server %s does not offer STARTTLS (use imap_tls=implicit or none)", addr)
+		}
+		if ok {
+			if err := c.StartTLS(tc); err != nil {
+				c.Logout()
+				return nil, fmt.Errorf("imap STARTTLS with %s: %w", addr, err)
+			}
+		}
+	}
+	if err := login(c); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("imap login: %w", err)
+	}
+	return c, nil
+}
+
+// FetchSince fetches every message with a UID greater than after from the
+// selected mailbox without marking them as seen. Messages that came back
+// without a body or could not be parsed are listed in failed.
+func FetchSince(c *client.Client, after uint32) (msgs []*InboundMessage, failed []uint32, err error) {
+	seq := new(imap.SeqSet)
+	seq.AddRange(after+1, 0)
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, section.FetchItem()}
+
+	ch := make(chan *imap.Message, 16)
+	done := make(chan error, 1)
+	go func() { done <- c.UidFetch(seq, items, ch) }()
+
+	for msg := range ch {
+		// "n:*" always matches the newest message, even when its UID is below n
+		if msg.Uid <= after {
+			continue
+		}
+		body := msg.GetBody(section)
+		if body == nil {
+			failed = append(failed, msg.Uid)
+			continue
+		}
+		in, err := ParseInbound(body)
+		if err != nil {
+			failed = append(failed, msg.Uid)
+			continue
+		}
+		in.UID = msg.Uid
+		msgs = append(msgs, in)
+	}
+	if err := <-done; err != nil {
+		return nil, nil, err
+	}
+	return msgs, failed, nil
+}
+
+// ParseInbound reads the headers, the text body and attachments of a raw message
+func ParseInbound(r io.Reader) (*InboundMessage, error) {
+	mr, err := gomail.CreateReader(r)
+	if err != nil && mr == nil {
+		return nil, err
+	}
+	defer mr.Close()
+
+	in := &InboundMessage{}
+	h := mr.Header
+	in.Subject, _ = h.Subject()
+	in.Date, _ = h.Date()
+	in.MessageID, _ = h.MessageID()
+	in.References, _ = h.MsgIDList("References")
+	if from, err := h.AddressList("From"); err == nil && len(from) > 0 {
+		in.From, in.FromName = from[0].Address, from[0].Name
+	}
+	if replyTo, err := h.AddressList("Reply-To"); err == nil && len(replyTo) > 0 {
+		in.ReplyTo = replyTo[0].Address
+	}
+
+	var plain, html string
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// keep what was readable from a malformed message
+			break
+		}
+		switch ph := p.Header.(type) {
+		case *gomail.InlineHeader:
+			ctype, _, _ := ph.ContentType()
+			data, _ := io.ReadAll(io.LimitReader(p.Body, maxInboundText))
+			switch {
+			case ctype == "text/plain" && plain == "":
+				plain = string(data)
+			case ctype == "text/html" && html == "":
+				html = string(data)
+			}
+		case *gomail.AttachmentHeader:
+			name, _ := ph.Filename()
+			ctype, _, _ := ph.ContentType()
+			data, err := io.ReadAll(io.LimitReader(p.Body, maxInboundAttachment+1))
+			if err != nil {
+				continue
+			}
+			if name == "" {
+				name = "attachment"
+				if exts, _ := mime.ExtensionsByType(ctype); len(exts) > 0 {
+					name += exts[0]
+				}
+			}
+			in.Attachments = append(in.Attachments, Attachment{Name: name, ContentType: ctype, Data: data})
+		}
+	}
+
+	in.Text = plain
+	if in.Text == "" && html != "" {
+		in.Text = HTMLToText(html)
+	}
+	in.Text = strings.TrimSpace(strings.ReplaceAll(in.Text, "\r\n", "\n"))
+	return in, nil
+}
+
+var (
+	htmlDropRe  = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</tr>|</h[1-6]>|</li>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+	blankRunRe  = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText gives a rough plain text rendering of an HTML body
+func HTMLToText(html string) string {
+	s := htmlDropRe.ReplaceAllString(html, "")
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = htmlTagRe.ReplaceAllString(s, "")
+	s = strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'").Replace(s)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	return blankRunRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
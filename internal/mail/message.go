@@ -0,0 +1,293 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Attachment is a file attached to a Message
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	// ContentID names an inline image so the HTML body can show it as cid:<ContentID>
+	ContentID string
+}
+
+// Message is an outgoing email. Bytes renders it as RFC 5322 text with CRLF
+// line endings, ready for DKIM signing and SMTP.
+type Message struct {
+	From        string
+	To          []string
+	Cc          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+	Date        time.Time
+
+	// HTML is sent alongside a plain text version (Body, or derived from the
+	// HTML when Body is empty). Inline images are embedded as multipart/related
+	// parts; those the HTML does not reference yet are appended to its end.
+	HTML   string
+	Inline []Attachment
+
+	// MessageID is the message's ID including angle brackets; Bytes fills in
+	// a new one when it is empty
+	MessageID string
+
+	// InReplyTo and References thread the message as a reply; they hold
+	// message IDs including the angle brackets
+	InReplyTo  string
+	References []string
+
+	// PGPSigner signs the message (RFC 3156 multipart/signed) when set
+	PGPSigner *openpgp.Entity
+	// PGPRecipients encrypts the message to these keys (RFC 3156
+	// multipart/encrypted) when set; it is also signed when PGPSigner is set
+	PGPRecipients []*openpgp.Entity
+
+	// SMIMESigner signs the message with S/MIME when set. It takes the place
+	// of PGPSigner; PGP encryption, if requested, wraps the S/MIME signed entity.
+	SMIMESigner *SMIMESigner
+}
+
+// Bytes renders the message
+func (m *Message) Bytes() ([]byte, error) {
+	entity, err := m.entity()
+	if err != nil {
+		return nil, err
+	}
+	pgpSigner := m.PGPSigner
+	if m.SMIMESigner != nil {
+		if entity, err = smimeSign(entity, m.SMIMESigner); err != nil {
+			return nil, err
+		}
+		pgpSigner = nil
+	}
+	switch {
+	case len(m.PGPRecipients) > 0:
+		entity, err = pgpEncrypt(entity, m.PGPRecipients, pgpSigner)
+	case pgpSigner != nil:
+		entity, err = pgpSign(entity, pgpSigner)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	writeHeader(&buf, "From", m.From)
+	writeHeader(&buf, "To", strings.Join(m.To, ", "))
+	if len(m.Cc) > 0 {
+		writeHeader(&buf, "Cc", strings.Join(m.Cc, ", "))
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	if m.MessageID == "" {
+		m.MessageID = NewMessageID(m.From)
+	}
+	writeHeader(&buf, "Message-ID", m.MessageID)
+	if m.InReplyTo != "" {
+		writeHeader(&buf, "In-Reply-To", m.InReplyTo)
+	}
+	if len(m.References) > 0 {
+		writeFoldedList(&buf, "References", m.References)
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+	buf.Write(entity)
+	return buf.Bytes(), nil
+}
+
+// NewMessageID returns a unique message ID in the domain of the from address
+func NewMessageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+	var b [12]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b[:]), domain)
+}
+
+// entity renders the MIME body: its Content-* header fields, a blank line and
+// the encoded content. It is what gets signed or encrypted.
+func (m *Message) entity() ([]byte, error) {
+	body, err := m.bodyEntity()
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Attachments) == 0 {
+		return body, nil
+	}
+	parts := [][]byte{body}
+	for _, a := range m.Attachments {
+		part, err := attachmentEntity(a, "attachment")
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return wrapMultipart("mixed", nil, parts...), nil
+}
+
+// bodyEntity renders the text of the message: plain text alone, or for HTML a
+// multipart/alternative, wrapped in multipart/related when images are inline
+func (m *Message) bodyEntity() ([]byte, error) {
+	if m.HTML == "" {
+		return textEntity("text/plain", m.Body)
+	}
+	plain := m.Body
+	if plain == "" {
+		plain = strings.TrimSpace(HTMLToText(m.HTML))
+	}
+	html := m.HTML
+	var missing strings.Builder
+	for _, img := range m.Inline {
+		if !strings.Contains(html, "cid:"+img.ContentID) {
+			missing.WriteString(`<p><img src="cid:` + img.ContentID + `" alt="` + img.Name + `"></p>`)
+		}
+	}
+	if missing.Len() > 0 {
+		if i := strings.LastIndex(strings.ToLower(html), "</body>"); i >= 0 {
+			html = html[:i] + missing.String() + html[i:]
+		} else {
+			html += missing.String()
+		}
+	}
+
+	plainPart, err := textEntity("text/plain", plain)
+	if err != nil {
+		return nil, err
+	}
+	htmlPart, err := textEntity("text/html", html)
+	if err != nil {
+		return nil, err
+	}
+	alt := wrapMultipart("alternative", nil, plainPart, htmlPart)
+	if len(m.Inline) == 0 {
+		return alt, nil
+	}
+	parts := [][]byte{alt}
+	for _, img := range m.Inline {
+		part, err := attachmentEntity(img, "inline")
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return wrapMultipart("related", map[string]string{"type": "multipart/alternative"}, parts...), nil
+}
+
+// wrapMultipart renders a multipart entity of the given subtype whose parts are
+// complete entities (header fields, blank line, content) written verbatim
+func wrapMultipart(subtype string, params map[string]string, parts ...[]byte) []byte {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	p := map[string]string{"boundary": boundary}
+	for k, v := range params {
+		p[k] = v
+	}
+	var buf bytes.Buffer
+	writeHeader(&buf, "Content-Type", mime.FormatMediaType("multipart/"+subtype, p))
+	buf.WriteString("\r\n")
+	for _, part := range parts {
+		buf.WriteString("--" + boundary + "\r\n")
+		buf.Write(part)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("--" + boundary + "--\r\n")
+	return buf.Bytes()
+}
+
+// writeHeader writes one header line, dropping line breaks so user input can't inject headers
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+// writeFoldedList writes a header holding a list of tokens, one per line, so
+// long reference chains stay within the line length limit
+func writeFoldedList(buf *bytes.Buffer, name string, values []string) {
+	clean := strings.NewReplacer("\r", "", "\n", "", " ", "")
+	buf.WriteString(name + ":")
+	for _, v := range values {
+		buf.WriteString(" " + clean.Replace(v) + "\r\n")
+	}
+}
+
+// textEntity renders a quoted-printable UTF-8 text part
+func textEntity(ctype, text string) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader(&buf, "Content-Type", ctype+"; charset=utf-8")
+	writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	if err := writeQuotedPrintable(&buf, text); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// attachmentEntity renders a base64 encoded file part; disposition is
+// "attachment" or "inline"
+func attachmentEntity(a Attachment, disposition string) ([]byte, error) {
+	ctype := a.ContentType
+	if ctype == "" {
+		ctype = mime.TypeByExtension(filepath.Ext(a.Name))
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	var buf bytes.Buffer
+	writeHeader(&buf, "Content-Type", ctype)
+	writeHeader(&buf, "Content-Transfer-Encoding", "base64")
+	writeHeader(&buf, "Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Name}))
+	if a.ContentID != "" {
+		writeHeader(&buf, "Content-ID", "<"+a.ContentID+">")
+	}
+	buf.WriteString("\r\n")
+	if err := writeBase64(&buf, a.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodedSize is the size of n bytes once base64 encoded in 76 character lines
+func EncodedSize(n int) int {
+	enc := (n + 2) / 3 * 4
+	return enc + (enc+75)/76*2
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for i := 0; i < len(encoded); i += 76 {
+		end := min(i+76, len(encoded))
+		if _, err := fmt.Fprintf(w, "%s\r\n", encoded[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var pgpConfig = &packet.Config{DefaultHash: crypto.SHA256}
+
+// ReadPGPKeys parses an ASCII armored key or key ring
+func ReadPGPKeys(armored []byte) (openpgp.EntityList, error) {
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("read PGP key: %w", err)
+	}
+	return keys, nil
+}
+
+// PGPKeyEmails returns the lower-cased email addresses of the key's user IDs
+func PGPKeyEmails(e *openpgp.Entity) []string {
+	var emails []string
+	for _, id := range e.Identities {
+		addr := id.UserId.Email
+		if addr == "" {
+			if a, err := mail.ParseAddress(id.Name); err == nil {
+				addr = a.Address
+			}
+		}
+		if addr != "" {
+			emails = append(emails, strings.ToLower(addr))
+		}
+	}
+	return emails
+}
+
+// PGPFingerprint formats the primary key fingerprint as upper-case hex
+func PGPFingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+// LoadPGPSigner reads an armored private key and unlocks it with passphrase
+func LoadPGPSigner(path, passphrase string) (*openpgp.Entity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read PGP signing key: %w", err)
+	}
+	return ParsePGPSigner(data, passphrase)
+}
+
+// ParsePGPSigner is LoadPGPSigner for a key already in memory
+func ParsePGPSigner(data []byte, passphrase string) (*openpgp.Entity, error) {
+	keys, err := ReadPGPKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 || keys[0].PrivateKey == nil {
+		return nil, errors.New("PGP signing key file holds no private key")
+	}
+	signer := keys[0]
+	if signer.PrivateKey.Encrypted {
+		if err := signer.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("unlock PGP signing key: %w", err)
+		}
+	}
+	return signer, nil
+}
+
+// pgpSign wraps entity in an RFC 3156 multipart/signed entity
+func pgpSign(entity []byte, signer *openpgp.Entity) ([]byte, error) {
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(entity), pgpConfig); err != nil {
+		return nil, fmt.Errorf("PGP sign: %w", err)
+	}
+	sigPart := "Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n" +
+		"Content-Description: OpenPGP digital signature\r\n" +
+		"Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n" +
+		crlf(sig.String())
+	return wrapMultipart("signed", map[string]string{
+		"micalg":   "pgp-sha256",
+		"protocol": "application/pgp-signature",
+	}, entity, []byte(sigPart)), nil
+}
+
+// pgpEncrypt wraps entity in an RFC 3156 multipart/encrypted entity, signing
+// the plaintext as well when signer is set
+func pgpEncrypt(entity []byte, recipients []*openpgp.Entity, signer *openpgp.Entity) ([]byte, error) {
+	var armored bytes.Buffer
+	aw, err := armor.Encode(&armored, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := openpgp.Encrypt(aw, recipients, signer, nil, pgpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("PGP encrypt: %w", err)
+	}
+	if _, err := pw.Write(entity); err != nil {
+		return nil, fmt.Errorf("PGP encrypt: %w", err)
+	}
+	if err := pw.Close(); err != nil {
+		return nil, fmt.Errorf("PGP encrypt: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	version := "Content-Type: application/pgp-encrypted\r\n" +
+		"Content-Description: PGP/MIME version identification\r\n\r\n" +
+		"Version: 1\r\n"
+	payload := "Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n" +
+		"Content-Description: OpenPGP encrypted message\r\n" +
+		"Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n" +
+		crlf(armored.String()) + "\r\n"
+	return wrapMultipart("encrypted", map[string]string{
+		"protocol": "application/pgp-encrypted",
+	}, []byte(version), []byte(payload)), nil
+}
+
+// crlf converts bare LF line endings to CRLF
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
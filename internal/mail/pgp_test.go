@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var testPGPConfig = &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+
+func newTestEntity(t *testing.T, name, email string) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", email, testPGPConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// publicOnly round-trips the public half of e the way stored contact keys are kept
+func publicOnly(t *testing.T, e *openpgp.Entity) *openpgp.Entity {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := e.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := openpgp.ReadKeyRing(&buf)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("read public key: %v", err)
+	}
+	return keys[0]
+}
+
+// accountSigner exports e as a passphrase protected armored private key and
+// loads it back the way an account's signing key is
+func accountSigner(t *testing.T, e *openpgp.Entity) *openpgp.Entity {
+	t.Helper()
+	if err := e.EncryptPrivateKeys([]byte("passphrase"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if _, err := ParsePGPSigner(buf.Bytes(), "wrong"); err == nil {
+		t.Fatal("ParsePGPSigner accepted the wrong passphrase")
+	}
+	signer, err := ParsePGPSigner(buf.Bytes(), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// partBody returns the decoded body of a MIME part
+func partBody(t *testing.T, part []byte) (contentType string, body []byte) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(part))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(msg.Body)
+	return msg.Header.Get("Content-Type"), body
+}
+
+func TestPGPSignVerifies(t *testing.T) {
+	key := newTestEntity(t, "Bot", "bot@example.com")
+	m := &Message{
+		From:      "bot@example.com",
+		To:        []string{"alice@example.com"},
+		Subject:   "Signed",
+		Body:      "Hello Alice,\nthis message is signed.\n",
+		PGPSigner: accountSigner(t, key),
+	}
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, sigPart := twoParts(t, raw, "signed", "application/pgp-signature")
+	ct, sig := partBody(t, sigPart)
+	if !strings.HasPrefix(ct, "application/pgp-signature") {
+		t.Fatalf("signature part Content-Type = %q", ct)
+	}
+
+	// only the account's public key is known to the receiver
+	keyring := openpgp.EntityList{publicOnly(t, key)}
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(sig), nil)
+	if err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	if signer.PrimaryKey.KeyId != key.PrimaryKey.KeyId {
+		t.Error("signature was not made with the account key")
+	}
+
+	altered := bytes.Replace(signed, []byte("Alice"), []byte("Mallory"), 1)
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(altered), bytes.NewReader(sig), nil); err == nil {
+		t.Error("signature verifies over altered content")
+	}
+}
+
+func TestPGPEncryptRoundTrip(t *testing.T) {
+	alice := newTestEntity(t, "Alice", "alice@example.com")
+	bot := newTestEntity(t, "Bot", "bot@example.com")
+	m := &Message{
+		From:          "bot@example.com",
+		To:            []string{"alice@example.com"},
+		Subject:       "Secret",
+		Body:          "The launch code is 0000.\n",
+		Attachments:   []Attachment{{Name: "plan.txt", ContentType: "text/plain", Data: []byte("step one")}},
+		PGPSigner:     accountSigner(t, bot),
+		PGPRecipients: []*openpgp.Entity{publicOnly(t, alice)},
+	}
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("launch code")) || bytes.Contains(raw, []byte("plan.txt")) {
+		t.Fatal("encrypted message leaks the plaintext")
+	}
+
+	version, payload := twoParts(t, raw, "encrypted", "application/pgp-encrypted")
+	if ct, body := partBody(t, version); ct != "application/pgp-encrypted" || strings.TrimSpace(string(body)) != "Version: 1" {
+		t.Errorf("version part = %q %q", ct, body)
+	}
+	ct, armored := partBody(t, payload)
+	if !strings.HasPrefix(ct, "application/octet-stream") {
+		t.Errorf("payload Content-Type = %q", ct)
+	}
+
+	decrypt := func(keyring openpgp.EntityList) (*openpgp.MessageDetails, []byte, error) {
+		block, err := armor.Decode(bytes.NewReader(armored))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Type != "PGP MESSAGE" {
+			t.Fatalf("armor type %q", block.Type)
+		}
+		md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		plain, err := io.ReadAll(md.UnverifiedBody)
+		return md, plain, err
+	}
+
+	md, plain, err := decrypt(openpgp.EntityList{alice, publicOnly(t, bot)})
+	if err != nil {
+		t.Fatalf("recipient cannot decrypt: %v", err)
+	}
+	for _, want := range []string{"launch code is 0000", "plan.txt", "multipart/mixed"} {
+		if !strings.Contains(string(plain), want) {
+			t.Errorf("decrypted entity lacks %q:\n%s", want, plain)
+		}
+	}
+	if !md.IsSigned || md.SignatureError != nil || md.SignedByKeyId != bot.PrimaryKey.KeyId {
+		t.Errorf("inner signature: signed %v by %X, error %v", md.IsSigned, md.SignedByKeyId, md.SignatureError)
+	}
+
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+	if _, _, err := decrypt(openpgp.EntityList{mallory}); err == nil {
+		t.Error("a key the message was not encrypted to decrypted it")
+	}
+}
@@ -0,0 +1,207 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Pool keeps one authenticated smtp.Client per account and reuses it for
+// consecutive messages, so batch sends pay for TCP, TLS and AUTH only once.
+// Connections are closed after sitting idle and re-dialed transparently when
+// the server drops them. Sends for the same key are serialized.
+type Pool struct {
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[string]*pooledConn
+}
+
+type pooledConn struct {
+	mu    sync.Mutex
+	c     *smtp.Client
+	timer *time.Timer
+	// live mirrors c so a cancelled send can close it without pc.mu
+	live atomic.Pointer[smtp.Client]
+	// uses counts sends so a stale idle timer leaves a reused connection alone
+	uses uint64
+	// removed is set once the entry left p.conns; senders must look it up again
+	removed bool
+}
+
+// NewPool creates a pool that closes connections unused for idleTimeout
+func NewPool(idleTimeout time.Duration) *Pool {
+	return &Pool{
+		idleTimeout: idleTimeout,
+		conns:       make(map[string]*pooledConn),
+	}
+}
+
+// Send delivers msg over the cached connection for key, dialing cfg and
+// authenticating with auth when there is none. A reused connection that
+// turns out to be dead (421, EOF, broken pipe, reset) is replaced and the
+// message retried once. Cancelling ctx aborts the transaction by closing the
+// connection; a dial in progress is only bounded by cfg.Timeout.
+func (p *Pool) Send(ctx context.Context, key string, cfg DialConfig, auth smtp.Auth, from string, to []string, msg []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pc := p.acquire(key)
+	defer pc.mu.Unlock()
+	pc.uses++
+	if pc.timer != nil {
+		pc.timer.Stop()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, pc.abort)
+	defer stop()
+
+	reused := false
+	if pc.c != nil {
+		// clear any state left by the previous transaction
+		if err := pc.c.Reset(); err != nil {
+			pc.close()
+		} else {
+			reused = true
+		}
+	}
+
+	err := pc.send(cfg, auth, from, to, msg)
+	if err != nil && reused && isConnError(err) && ctx.Err() == nil {
+		pc.close()
+		err = pc.send(cfg, auth, from, to, msg)
+	}
+	if err != nil && isConnError(err) {
+		pc.close()
+	}
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("send aborted: %w", ctx.Err())
+	}
+
+	if pc.c != nil {
+		gen := pc.uses
+		pc.timer = time.AfterFunc(p.idleTimeout, func() { p.expire(key, pc, gen) })
+	}
+	return err
+}
+
+// acquire returns the locked entry for key, creating it when missing
+func (p *Pool) acquire(key string) *pooledConn {
+	for {
+		p.mu.Lock()
+		pc, ok := p.conns[key]
+		if !ok {
+			pc = &pooledConn{}
+			p.conns[key] = pc
+		}
+		p.mu.Unlock()
+
+		pc.mu.Lock()
+		if !pc.removed {
+			return pc
+		}
+		// expired while we waited for it
+		pc.mu.Unlock()
+	}
+}
+
+// expire ends an idle session and forgets its entry, unless a send used
+// the connection after the timer was set
+func (p *Pool) expire(key string, pc *pooledConn, gen uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.uses != gen || pc.removed {
+		return
+	}
+	pc.quit()
+	pc.removed = true
+	if p.conns[key] == pc {
+		delete(p.conns, key)
+	}
+}
+
+// send dials when needed and runs one transaction; pc.mu must be held
+func (pc *pooledConn) send(cfg DialConfig, auth smtp.Auth, from string, to []string, msg []byte) error {
+	if pc.c == nil {
+		c, err := Dial(cfg)
+		if err != nil {
+			return err
+		}
+		if err := authenticate(c, auth); err != nil {
+			c.Close()
+			return err
+		}
+		pc.c = c
+		pc.live.Store(c)
+	}
+	return deliver(pc.c, from, to, msg)
+}
+
+// abort closes the connection under a running transaction, making it fail
+func (pc *pooledConn) abort() {
+	if c := pc.live.Load(); c != nil {
+		c.Close()
+	}
+}
+
+// quit politely ends the session; pc.mu must be held
+func (pc *pooledConn) quit() {
+	if pc.c != nil {
+		if err := pc.c.Quit(); err != nil {
+			pc.c.Close()
+		}
+		pc.c = nil
+		pc.live.Store(nil)
+	}
+}
+
+// close drops the connection without QUIT; pc.mu must be held
+func (pc *pooledConn) close() {
+	if pc.c != nil {
+		pc.c.Close()
+		pc.c = nil
+		pc.live.Store(nil)
+	}
+}
+
+// Close ends every pooled session
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pc := range p.conns {
+		pc.mu.Lock()
+		if pc.timer != nil {
+			pc.timer.Stop()
+		}
+		pc.quit()
+		pc.removed = true
+		pc.mu.Unlock()
+		delete(p.conns, key)
+	}
+}
+
+// isConnError reports whether err means the connection is unusable rather
+// than the message being rejected
+func isConnError(err error) bool {
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code == 421
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
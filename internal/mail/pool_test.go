@@ -0,0 +1,270 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSMTP is a plain SMTP server that accepts whole transactions and
+// records the verbs each connection sent. fault, when set, is asked before
+// every reply and can make the server answer differently or hang up.
+type recordingSMTP struct {
+	mu       sync.Mutex
+	sessions [][]string
+	fault    func(conn int, verb string) (reply string, hangup bool)
+	// stall, when set, keeps the end of DATA unanswered until it is closed
+	stall chan struct{}
+}
+
+func (s *recordingSMTP) start(t *testing.T) DialConfig {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.sessions = append(s.sessions, nil)
+			id := len(s.sessions) - 1
+			s.mu.Unlock()
+			go s.serve(id, conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return DialConfig{Host: addr.IP.String(), Port: addr.Port, Mode: TLSOpportunistic, Timeout: 5 * time.Second}
+}
+
+func (s *recordingSMTP) serve(id int, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	write("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " x")[0])
+		s.mu.Lock()
+		s.sessions[id] = append(s.sessions[id], verb)
+		fault := s.fault
+		s.mu.Unlock()
+		if fault != nil {
+			if reply, hangup := fault(id, verb); hangup {
+				return
+			} else if reply != "" {
+				write(reply)
+				continue
+			}
+		}
+		switch verb {
+		case "EHLO", "HELO":
+			write("250 fake")
+		case "DATA":
+			write("354 go ahead")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+			}
+			if s.stall != nil {
+				<-s.stall
+				return
+			}
+			write("250 queued")
+		case "QUIT":
+			write("221 bye")
+			return
+		default:
+			write("250 ok")
+		}
+	}
+}
+
+// conns returns a copy of the verbs seen on each connection
+func (s *recordingSMTP) conns() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([][]string, len(s.sessions))
+	for i, verbs := range s.sessions {
+		out[i] = append([]string(nil), verbs...)
+	}
+	return out
+}
+
+func poolSend(p *Pool, cfg DialConfig) error {
+	return p.Send(context.Background(), "acct", cfg, nil, "bot@example.com", []string{"alice@example.com"}, []byte("Subject: hi\r\n\r\nhello\r\n"))
+}
+
+func TestPoolReusesConnection(t *testing.T) {
+	srv := &recordingSMTP{}
+	cfg := srv.start(t)
+	p := NewPool(time.Minute)
+	defer p.Close()
+
+	for range 2 {
+		if err := poolSend(p, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conns := srv.conns()
+	if len(conns) != 1 {
+		t.Fatalf("%d connections for two sends, want 1", len(conns))
+	}
+	got := strings.Join(conns[0], " ")
+	if want := "EHLO MAIL RCPT DATA RSET MAIL RCPT DATA"; got != want {
+		t.Errorf("commands = %s, want %s", got, want)
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	srv := &recordingSMTP{}
+	cfg := srv.start(t)
+	p := NewPool(50 * time.Millisecond)
+	defer p.Close()
+
+	if err := poolSend(p, cfg); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conns := srv.conns()
+		if n := len(conns[0]); n > 0 && conns[0][n-1] == "QUIT" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle connection not closed: %v", conns[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.mu.Lock()
+	left := len(p.conns)
+	p.mu.Unlock()
+	if left != 0 {
+		t.Errorf("%d pool entries left after the idle timeout", left)
+	}
+
+	// the next send dials again
+	if err := poolSend(p, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.conns()); n != 2 {
+		t.Errorf("%d connections, want a new one after the idle timeout", n)
+	}
+}
+
+func TestPoolReconnectsDeadConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  string
+		hangup bool
+	}{
+		{name: "421 on MAIL", reply: "421 closing, idle too long"},
+		{name: "hangup on MAIL", hangup: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &recordingSMTP{}
+			cfg := srv.start(t)
+			p := NewPool(time.Minute)
+			defer p.Close()
+
+			if err := poolSend(p, cfg); err != nil {
+				t.Fatal(err)
+			}
+			// the first connection dies at the next transaction
+			srv.mu.Lock()
+			srv.fault = func(conn int, verb string) (string, bool) {
+				if conn == 0 && verb == "MAIL" {
+					return tt.reply, tt.hangup
+				}
+				return "", false
+			}
+			srv.mu.Unlock()
+
+			if err := poolSend(p, cfg); err != nil {
+				t.Fatalf("send over a dead connection: %v, want a transparent retry", err)
+			}
+			conns := srv.conns()
+			if len(conns) != 2 {
+				t.Fatalf("%d connections, want exactly one reconnect", len(conns))
+			}
+			if got := strings.Join(conns[1], " "); got != "EHLO MAIL RCPT DATA" {
+				t.Errorf("retry commands = %s", got)
+			}
+		})
+	}
+}
+
+func TestPoolNoRetryOnRejection(t *testing.T) {
+	srv := &recordingSMTP{}
+	cfg := srv.start(t)
+	p := NewPool(time.Minute)
+	defer p.Close()
+
+	if err := poolSend(p, cfg); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	srv.fault = func(conn int, verb string) (string, bool) {
+		if verb == "RCPT" {
+			return "550 no such user", false
+		}
+		return "", false
+	}
+	srv.mu.Unlock()
+	if err := poolSend(p, cfg); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("send error = %v, want the 550 rejection", err)
+	}
+	if n := len(srv.conns()); n != 1 {
+		t.Errorf("%d connections, a rejected recipient must not reconnect", n)
+	}
+}
+
+func TestPoolSendAborted(t *testing.T) {
+	srv := &recordingSMTP{stall: make(chan struct{})}
+	defer close(srv.stall)
+	cfg := srv.start(t)
+	p := NewPool(time.Minute)
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Send(ctx, "acct", cfg, nil, "bot@example.com", []string{"alice@example.com"}, []byte("Subject: hi\r\n\r\nhello\r\n"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "aborted") {
+			t.Fatalf("Send = %v, want an aborted error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send did not return after the context was cancelled")
+	}
+	if n := len(srv.conns()); n != 1 {
+		t.Errorf("%d connections, an aborted send must not be retried", n)
+	}
+
+	// a cancelled context is refused before dialing
+	if err := p.Send(ctx, "acct", cfg, nil, "bot@example.com", []string{"alice@example.com"}, []byte("x")); err == nil {
+		t.Error("Send with a cancelled context succeeded")
+	}
+}
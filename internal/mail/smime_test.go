@@ -0,0 +1,139 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// newTestPKCS12 returns a PKCS#12 bundle with a fresh self-signed signing certificate
+func newTestPKCS12(t *testing.T, password string) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "Bot"},
+		EmailAddresses: []string{"bot@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	p12, err := pkcs12.Modern.Encode(key, cert, nil, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p12
+}
+
+// twoParts splits a multipart/<subtype> message with the given protocol into
+// the exact bytes of its two body parts
+func twoParts(t *testing.T, raw []byte, subtype, protocol string) (first, second []byte) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/"+subtype || params["protocol"] != protocol {
+		t.Fatalf("Content-Type = %s %v, want multipart/%s with %s", mediaType, params, subtype, protocol)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	delim := []byte("--" + params["boundary"])
+	chunks := bytes.Split(body, delim)
+	// preamble, two parts, closing "--"
+	if len(chunks) != 4 {
+		t.Fatalf("multipart/%s has %d parts, want 2", subtype, len(chunks)-2)
+	}
+	first = bytes.TrimSuffix(bytes.TrimPrefix(chunks[1], []byte("\r\n")), []byte("\r\n"))
+	second = bytes.TrimPrefix(chunks[2], []byte("\r\n"))
+	return first, second
+}
+
+// signedParts splits a multipart/signed message into the exact bytes of the
+// signed entity and the decoded signature
+func signedParts(t *testing.T, raw []byte) (signed, sig []byte) {
+	t.Helper()
+	signed, sigEntity := twoParts(t, raw, "signed", "application/pkcs7-signature")
+	sigPart, err := mail.ReadMessage(bytes.NewReader(sigEntity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := sigPart.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/pkcs7-signature") {
+		t.Fatalf("signature part Content-Type = %q", ct)
+	}
+	encoded, _ := io.ReadAll(sigPart.Body)
+	sig, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(encoded)), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed, sig
+}
+
+func TestSMIMESignVerifies(t *testing.T) {
+	signer, err := ParsePKCS12(newTestPKCS12(t, "secret"), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Message{
+		From:        "bot@example.com",
+		To:          []string{"alice@example.com"},
+		Subject:     "Signed",
+		Body:        "Hello Alice,\nthis message is signed.\n",
+		Attachments: []Attachment{{Name: "notes.txt", ContentType: "text/plain", Data: []byte("notes")}},
+		SMIMESigner: signer,
+	}
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, sig := signedParts(t, raw)
+
+	p7, err := pkcs7.Parse(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7.Content = signed
+	if err := p7.Verify(); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	if got := p7.GetOnlySigner(); got == nil || !got.Equal(signer.Cert) {
+		t.Error("signature was not made with the PKCS#12 certificate")
+	}
+
+	// the signature covers the entity: any change must break it
+	p7.Content = bytes.Replace(signed, []byte("Alice"), []byte("Mallory"), 1)
+	if err := p7.Verify(); err == nil {
+		t.Error("signature verifies over altered content")
+	}
+}
+
+func TestParsePKCS12WrongPassword(t *testing.T) {
+	if _, err := ParsePKCS12(newTestPKCS12(t, "secret"), "wrong"); err == nil {
+		t.Fatal("ParsePKCS12 accepted the wrong password")
+	}
+}
@@ -0,0 +1,189 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-sasl"
+)
+
+// Token endpoints of the common providers, used when no token URL is configured
+const (
+	GoogleTokenURL    = "https://oauth2.googleapis.com/token"
+	MicrosoftTokenURL = "https://login.microsoftonline.com/common/oauth2/v2.0/token"
+)
+
+// refreshMargin is how long before expiry a cached access token is replaced
+const refreshMargin = 2 * time.Minute
+
+// TokenSource exchanges a refresh token for access tokens and caches them until
+// shortly before they expire. It is safe for concurrent use.
+type TokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	Scope        string
+
+	// OnRefreshTokenChange is called when the server rotates the refresh token
+	// so the caller can persist the new one. Optional.
+	OnRefreshTokenChange func(refreshToken string)
+
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Token returns a valid access token, refreshing it when needed
+func (ts *TokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Add(refreshMargin).Before(ts.expiry) {
+		return ts.token, nil
+	}
+	if err := ts.refresh(); err != nil {
+		return "", err
+	}
+	return ts.token, nil
+}
+
+// Invalidate drops the cached access token so the next Token call refreshes it
+func (ts *TokenSource) Invalidate() {
+	ts.mu.Lock()
+	ts.token = ""
+	ts.mu.Unlock()
+}
+
+func (ts *TokenSource) refresh() error {
+	if ts.TokenURL == "" {
+		return errors.New("xoauth2: token URL not configured")
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {ts.ClientID},
+		"refresh_token": {ts.RefreshToken},
+	}
+	if ts.ClientSecret != "" {
+		form.Set("client_secret", ts.ClientSecret)
+	}
+	if ts.Scope != "" {
+		form.Set("scope", ts.Scope)
+	}
+
+	client := ts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.PostForm(ts.TokenURL, form)
+	if err != nil {
+		return fmt.Errorf("xoauth2: token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error"`
+		ErrorDesc    string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("xoauth2: token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		if body.Error != "" {
+			return fmt.Errorf("xoauth2: token refresh failed: %s %s", body.Error, body.ErrorDesc)
+		}
+		return fmt.Errorf("xoauth2: token refresh failed with HTTP %d", resp.StatusCode)
+	}
+
+	ts.token = body.AccessToken
+	if body.ExpiresIn <= 0 {
+		body.ExpiresIn = 3600
+	}
+	ts.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	if body.RefreshToken != "" && body.RefreshToken != ts.RefreshToken {
+		ts.RefreshToken = body.RefreshToken
+		if ts.OnRefreshTokenChange != nil {
+			ts.OnRefreshTokenChange(body.RefreshToken)
+		}
+	}
+	return nil
+}
+
+// xoauth2Auth implements smtp.Auth for the XOAUTH2 SASL mechanism
+type xoauth2Auth struct {
+	username string
+	host     string
+	source   *TokenSource
+}
+
+// XOAuth2Auth returns an smtp.Auth that authenticates username with bearer
+// tokens from source. Like smtp.PlainAuth it refuses to send the token over
+// an unencrypted connection unless the server is on localhost.
+func XOAuth2Auth(username, host string, source *TokenSource) smtp.Auth {
+	return &xoauth2Auth{username: username, host: host, source: source}
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("xoauth2: unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("xoauth2: wrong host name")
+	}
+	token, err := a.source.Token()
+	if err != nil {
+		return "", nil, err
+	}
+	resp := "user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"
+	return "XOAUTH2", []byte(resp), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// the server sends a JSON error as a challenge; the cached token was
+		// rejected, so make sure the next attempt fetches a fresh one
+		a.source.Invalidate()
+		return nil, fmt.Errorf("xoauth2: authentication rejected: %s", strings.TrimSpace(string(fromServer)))
+	}
+	return nil, nil
+}
+
+// xoauth2SASL is the XOAUTH2 mechanism for IMAP
+type xoauth2SASL struct {
+	username string
+	source   *TokenSource
+}
+
+// XOAuth2SASL returns a SASL client authenticating username with bearer tokens from source
+func XOAuth2SASL(username string, source *TokenSource) sasl.Client {
+	return &xoauth2SASL{username: username, source: source}
+}
+
+func (a *xoauth2SASL) Start() (string, []byte, error) {
+	token, err := a.source.Token()
+	if err != nil {
+		return "", nil, err
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+func (a *xoauth2SASL) Next(challenge []byte) ([]byte, error) {
+	a.source.Invalidate()
+	return nil, fmt.Errorf("xoauth2: authentication rejected: %s", strings.TrimSpace(string(challenge)))
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
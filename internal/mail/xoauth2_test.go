@@ -0,0 +1,133 @@
+package mail
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenServer is a stand-in OAuth token endpoint: every request gets a new
+// access token, and a new refresh token when rotate is set
+type tokenServer struct {
+	mu     sync.Mutex
+	forms  []map[string]string
+	rotate bool
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	form := map[string]string{}
+	for k := range r.PostForm {
+		form[k] = r.PostForm.Get(k)
+	}
+	s.forms = append(s.forms, form)
+	n := len(s.forms)
+	w.Header().Set("Content-Type", "application/json")
+	if s.rotate {
+		fmt.Fprintf(w, `{"access_token":"access-%d","expires_in":3600,"refresh_token":"refresh-%d"}`, n, n)
+		return
+	}
+	fmt.Fprintf(w, `{"access_token":"access-%d","expires_in":3600}`, n)
+}
+
+func (s *tokenServer) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.forms)
+}
+
+func TestTokenSourceCachesUntilMargin(t *testing.T) {
+	srv := &tokenServer{}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	ts := &TokenSource{TokenURL: hs.URL, ClientID: "id", ClientSecret: "secret", RefreshToken: "refresh-0", HTTPClient: hs.Client()}
+
+	for range 3 {
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok != "access-1" {
+			t.Fatalf("Token = %q, want the cached access-1", tok)
+		}
+	}
+	if n := srv.calls(); n != 1 {
+		t.Fatalf("%d token requests for three calls, want 1", n)
+	}
+	f := srv.forms[0]
+	if f["grant_type"] != "refresh_token" || f["client_id"] != "id" || f["client_secret"] != "secret" || f["refresh_token"] != "refresh-0" {
+		t.Errorf("token request form = %v", f)
+	}
+
+	// still outside the margin: keep the cached token
+	ts.expiry = time.Now().Add(refreshMargin + time.Minute)
+	if tok, _ := ts.Token(); tok != "access-1" || srv.calls() != 1 {
+		t.Fatalf("Token = %q after %d requests, want the cached token", tok, srv.calls())
+	}
+	// inside the margin: refresh before the token actually expires
+	ts.expiry = time.Now().Add(refreshMargin - time.Second)
+	if tok, _ := ts.Token(); tok != "access-2" || srv.calls() != 2 {
+		t.Fatalf("Token = %q after %d requests, want a refreshed access-2", tok, srv.calls())
+	}
+
+	ts.Invalidate()
+	if tok, _ := ts.Token(); tok != "access-3" {
+		t.Fatalf("Token after Invalidate = %q, want access-3", tok)
+	}
+}
+
+func TestTokenSourceRefreshTokenRotation(t *testing.T) {
+	srv := &tokenServer{rotate: true}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	var rotated []string
+	ts := &TokenSource{
+		TokenURL:             hs.URL,
+		ClientID:             "id",
+		RefreshToken:         "refresh-0",
+		HTTPClient:           hs.Client(),
+		OnRefreshTokenChange: func(rt string) { rotated = append(rotated, rt) },
+	}
+
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 1 || rotated[0] != "refresh-1" || ts.RefreshToken != "refresh-1" {
+		t.Fatalf("after the first refresh: callback got %v, RefreshToken = %q", rotated, ts.RefreshToken)
+	}
+	if _, ok := srv.forms[0]["client_secret"]; ok {
+		t.Error("sent an empty client_secret")
+	}
+
+	ts.Invalidate()
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.forms[1]["refresh_token"]; got != "refresh-1" {
+		t.Errorf("second refresh used %q, want the rotated refresh-1", got)
+	}
+	if len(rotated) != 2 || rotated[1] != "refresh-2" {
+		t.Errorf("callback calls = %v, want refresh-1, refresh-2", rotated)
+	}
+}
+
+func TestTokenSourceError(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Token has been revoked"}`)
+	}))
+	defer hs.Close()
+	ts := &TokenSource{TokenURL: hs.URL, ClientID: "id", RefreshToken: "old", HTTPClient: hs.Client()}
+	if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Token error = %v, want invalid_grant", err)
+	}
+}
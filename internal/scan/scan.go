@@ -0,0 +1,135 @@
+// Package scan checks uploaded files for malware before they are mailed.
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Result is the verdict on one file
+type Result struct {
+	Infected bool
+	// Signature names what was found when Infected is set
+	Signature string
+}
+
+// Scanner inspects file content
+type Scanner interface {
+	Scan(r io.Reader) (Result, error)
+}
+
+// Nop accepts every file; it is used when no scanner is configured
+type Nop struct{}
+
+// Scan implements Scanner
+func (Nop) Scan(io.Reader) (Result, error) { return Result{}, nil }
+
+const (
+	defaultClamdTimeout = 2 * time.Minute
+	clamdChunkSize      = 64 << 10
+)
+
+// Clamd scans with a ClamAV daemon using the INSTREAM command
+type Clamd struct {
+	Network string // "tcp" or "unix"
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamd parses a clamd address: tcp://host:3310, unix:///run/clamav/clamd.ctl,
+// a bare host:port or an absolute socket path
+func NewClamd(addr string) (*Clamd, error) {
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		return &Clamd{Network: "tcp", Address: strings.TrimPrefix(addr, "tcp://")}, nil
+	case strings.HasPrefix(addr, "unix://"):
+		return &Clamd{Network: "unix", Address: strings.TrimPrefix(addr, "unix://")}, nil
+	case strings.HasPrefix(addr, "/"):
+		return &Clamd{Network: "unix", Address: addr}, nil
+	case strings.Contains(addr, ":"):
+		return &Clamd{Network: "tcp", Address: addr}, nil
+	}
+	return nil, fmt.Errorf("clamd address %q: use tcp://host:port or unix:///path/to/socket", addr)
+}
+
+// Scan streams r to clamd and parses its verdict
+func (c *Clamd) Scan(r io.Reader) (Result, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultClamdTimeout
+	}
+	conn, err := net.DialTimeout(c.Network, c.Address, timeout)
+	if err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	// z-prefixed commands are terminated by NUL, and so is the reply
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return Result{}, c.streamError(conn, err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return Result{}, c.streamError(conn, err)
+			}
+		}
+		if errors.Is(rerr, io.EOF) {
+			break
+		}
+		if rerr != nil {
+			return Result{}, rerr
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return Result{}, c.streamError(conn, err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// streamError prefers clamd's own explanation (such as the stream size limit)
+// over the write error it causes by closing the connection
+func (c *Clamd) streamError(conn net.Conn, err error) error {
+	var reply [256]byte
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _ := conn.Read(reply[:]); n > 0 {
+		return fmt.Errorf("clamd: %s", strings.TrimSpace(string(bytes.TrimRight(reply[:n], "\x00"))))
+	}
+	return fmt.Errorf("clamd: %w", err)
+}
+
+// parseReply reads "stream: OK", "stream: <signature> FOUND" or "... ERROR"
+func parseReply(reply string) (Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	msg := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case msg == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(msg, " FOUND")}, nil
+	case reply == "":
+		return Result{}, errors.New("clamd: empty reply")
+	}
+	return Result{}, fmt.Errorf("clamd: %s", reply)
+}